@@ -0,0 +1,17 @@
+module github.com/voidlinuxbr/voidbr-vinstall
+
+go 1.25.0
+
+require (
+	github.com/BurntSushi/toml v1.5.0
+	github.com/fatih/color v1.19.0
+	github.com/klauspost/compress v1.18.0
+	github.com/ulikunitz/xz v0.5.9
+	howett.net/plist v1.0.1
+)
+
+require (
+	github.com/mattn/go-colorable v0.1.14 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
+	golang.org/x/sys v0.42.0 // indirect
+)
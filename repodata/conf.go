@@ -0,0 +1,131 @@
+package repodata
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// ConfEntry é um par chave=valor do xbps.d e o arquivo de onde veio.
+type ConfEntry struct {
+	Key   string
+	Value string
+	File  string
+}
+
+// ReadConf lê os pares chave=valor dos arquivos xbps.d em ordem. Um
+// arquivo em /etc/xbps.d substitui o de mesmo nome em /usr/share/xbps.d.
+func ReadConf() []ConfEntry {
+	confs := make(map[string]string)
+	for _, dir := range []string{"/usr/share/xbps.d", "/etc/xbps.d"} {
+		matches, _ := filepath.Glob(filepath.Join(dir, "*.conf"))
+		for _, m := range matches {
+			confs[filepath.Base(m)] = m
+		}
+	}
+	names := make([]string, 0, len(confs))
+	for name := range confs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var entries []ConfEntry
+	for _, name := range names {
+		content, err := os.ReadFile(confs[name])
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(content), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if key, value, ok := strings.Cut(line, "="); ok {
+				entries = append(entries, ConfEntry{strings.TrimSpace(key), strings.TrimSpace(value), confs[name]})
+			}
+		}
+	}
+	return entries
+}
+
+// Repositories devolve os repositórios na ordem de prioridade do xbps, lidos
+// direto do xbps.d; o xbps-query -L fica apenas como último recurso.
+func Repositories() []string {
+	var urls []string
+	seen := make(map[string]bool)
+	for _, kv := range ReadConf() {
+		if kv.Key == "repository" && !seen[kv.Value] {
+			seen[kv.Value] = true
+			urls = append(urls, kv.Value)
+		}
+	}
+	if len(urls) > 0 {
+		return urls
+	}
+	out, err := exec.Command("xbps-query", "-L").Output()
+	if err != nil {
+		return urls
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 {
+			urls = append(urls, fields[1])
+		}
+	}
+	return urls
+}
+
+// NativeArch segue o xbps: XBPS_ARCH, 'architecture=' do xbps.d e por fim a
+// máquina (com sufixo -musl quando a libc é musl).
+func NativeArch() string {
+	if arch := os.Getenv("XBPS_ARCH"); arch != "" {
+		return arch
+	}
+	if arch := confArchitecture(); arch != "" {
+		return arch
+	}
+	machine := "x86_64"
+	var uts syscall.Utsname
+	if syscall.Uname(&uts) == nil {
+		machine = utsString(uts.Machine[:])
+	}
+	if isMusl() {
+		machine += "-musl"
+	}
+	return machine
+}
+
+// confArchitecture devolve o 'architecture=' dos arquivos xbps.d; como no
+// xbps, vale a última ocorrência.
+func confArchitecture() string {
+	arch := ""
+	for _, kv := range ReadConf() {
+		if kv.Key == "architecture" {
+			arch = kv.Value
+		}
+	}
+	return arch
+}
+
+func utsString[T int8 | uint8](field []T) string {
+	var sb strings.Builder
+	for _, c := range field {
+		if c == 0 {
+			break
+		}
+		sb.WriteByte(byte(c))
+	}
+	return sb.String()
+}
+
+func isMusl() bool {
+	for _, pattern := range []string{"/lib/ld-musl-*.so.1", "/usr/lib/ld-musl-*.so.1"} {
+		if matches, _ := filepath.Glob(pattern); len(matches) > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,33 @@
+package repodata
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNativeArch(t *testing.T) {
+	t.Setenv("XBPS_ARCH", "armv7l-musl")
+	if got := NativeArch(); got != "armv7l-musl" {
+		t.Errorf("com XBPS_ARCH: NativeArch() = %q", got)
+	}
+
+	// sem XBPS_ARCH vale o xbps.d ou a máquina; nunca vazio
+	t.Setenv("XBPS_ARCH", "")
+	arch := NativeArch()
+	if arch == "" || strings.ContainsAny(arch, " \x00") {
+		t.Errorf("NativeArch() = %q", arch)
+	}
+	if confArchitecture() == "" && isMusl() != strings.HasSuffix(arch, "-musl") {
+		t.Errorf("NativeArch() = %q com isMusl() = %v", arch, isMusl())
+	}
+}
+
+func TestUtsString(t *testing.T) {
+	field := []int8{'x', '8', '6', '_', '6', '4', 0, 'z', 'z'}
+	if got := utsString(field); got != "x86_64" {
+		t.Errorf("utsString = %q", got)
+	}
+	if got := utsString([]uint8{'a', 'a', 'r', 'c', 'h', '6', '4'}); got != "aarch64" {
+		t.Errorf("utsString sem NUL = %q", got)
+	}
+}
@@ -0,0 +1,173 @@
+// Package repodata lê os repositórios XBPS direto do disco: os arquivos
+// <arch>-repodata sincronizados pelo xbps-install -S e a configuração do
+// xbps.d, sem depender do xbps-query.
+package repodata
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"howett.net/plist"
+)
+
+// Package é uma entrada do index.plist de um repositório XBPS.
+type Package struct {
+	Name           string   `plist:"-"`
+	Repo           string   `plist:"-"`
+	Pkgver         string   `plist:"pkgver"`
+	ShortDesc      string   `plist:"short_desc"`
+	Maintainer     string   `plist:"maintainer"`
+	Architecture   string   `plist:"architecture"`
+	RunDepends     []string `plist:"run_depends"`
+	ShlibProvides  []string `plist:"shlib-provides"`
+	ShlibRequires  []string `plist:"shlib-requires"`
+	Provides       []string `plist:"provides"`
+	ConfFiles      []string `plist:"conf_files"`
+	FilenameSize   int64    `plist:"filename-size"`
+	InstalledSize  int64    `plist:"installed_size"`
+	FilenameSha256 string   `plist:"filename-sha256"`
+	BuildDate      string   `plist:"build-date"`
+	License        string   `plist:"license"`
+	Homepage       string   `plist:"homepage"`
+}
+
+// Repodata é o conteúdo de um arquivo <arch>-repodata: o índice de pacotes,
+// os metadados (chave pública) e os pacotes em stage, ainda não promovidos.
+type Repodata struct {
+	URI      string
+	Path     string
+	Packages map[string]*Package
+	Stage    map[string]*Package
+	Meta     map[string]interface{}
+}
+
+var (
+	magicGzip = []byte{0x1f, 0x8b}
+	magicXz   = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	magicZstd = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Path devolve onde o xbps guarda o repodata de uri: no próprio diretório
+// para repositórios locais, em /var/db/xbps para os remotos.
+func Path(uri, arch string) string {
+	file := arch + "-repodata"
+	if strings.HasPrefix(uri, "/") {
+		return filepath.Join(uri, file)
+	}
+	if strings.HasPrefix(uri, "file://") {
+		return filepath.Join(strings.TrimPrefix(uri, "file://"), file)
+	}
+	dirName := strings.NewReplacer(":", "_", "/", "_", ".", "_").Replace(uri)
+	return filepath.Join("/var/db/xbps", dirName, file)
+}
+
+// Decompress detecta o formato pelos bytes mágicos; repodata sem compressão
+// (tar puro) é devolvido como está.
+func Decompress(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(6)
+	switch {
+	case bytes.HasPrefix(head, magicGzip):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(head, magicXz):
+		xr, err := xz.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(xr), nil
+	case bytes.HasPrefix(head, magicZstd):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	}
+	return io.NopCloser(br), nil
+}
+
+// Open lê o repodata em path; uri só identifica a origem dos pacotes.
+func Open(path, uri string) (*Repodata, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader, err := Decompress(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	defer reader.Close()
+
+	repo := &Repodata{URI: uri, Path: path}
+	tr := tar.NewReader(reader)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		switch filepath.Base(hdr.Name) {
+		case "index.plist":
+			repo.Packages, err = DecodeIndex(data, uri)
+		case "stage.plist":
+			repo.Stage, err = DecodeIndex(data, uri)
+		case "index-meta.plist":
+			_, err = plist.Unmarshal(data, &repo.Meta)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", path, hdr.Name, err)
+		}
+	}
+	if repo.Packages == nil {
+		return nil, fmt.Errorf("%s: index.plist ausente", path)
+	}
+	return repo, nil
+}
+
+// DecodeIndex decodifica um index.plist (ou stage.plist) já extraído.
+func DecodeIndex(data []byte, uri string) (map[string]*Package, error) {
+	index := make(map[string]*Package)
+	if len(bytes.TrimSpace(data)) == 0 {
+		return index, nil
+	}
+	if _, err := plist.Unmarshal(data, &index); err != nil {
+		return nil, err
+	}
+	for name, pkg := range index {
+		pkg.Name = name
+		pkg.Repo = uri
+	}
+	return index, nil
+}
+
+// OpenAll abre o repodata de cada repositório configurado, na ordem de
+// prioridade do xbps. Repositórios ainda não sincronizados são ignorados.
+func OpenAll(arch string) ([]*Repodata, error) {
+	var repos []*Repodata
+	for _, uri := range Repositories() {
+		repo, err := Open(Path(uri, arch), uri)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return repos, err
+		}
+		repos = append(repos, repo)
+	}
+	return repos, nil
+}
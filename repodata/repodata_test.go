@@ -0,0 +1,174 @@
+package repodata
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"howett.net/plist"
+)
+
+func TestPath(t *testing.T) {
+	tests := []struct{ uri, arch, want string }{
+		{"https://repo-default.voidlinux.org/current", "x86_64",
+			"/var/db/xbps/https___repo-default_voidlinux_org_current/x86_64-repodata"},
+		{"https://repo-fastly.voidlinux.org/current/musl/nonfree", "x86_64-musl",
+			"/var/db/xbps/https___repo-fastly_voidlinux_org_current_musl_nonfree/x86_64-musl-repodata"},
+		{"/hostdir/binpkgs", "aarch64", "/hostdir/binpkgs/aarch64-repodata"},
+		{"file:///hostdir/binpkgs/nonfree", "x86_64", "/hostdir/binpkgs/nonfree/x86_64-repodata"},
+	}
+	for _, tt := range tests {
+		if got := Path(tt.uri, tt.arch); got != tt.want {
+			t.Errorf("Path(%q, %q) = %q, quer %q", tt.uri, tt.arch, got, tt.want)
+		}
+	}
+}
+
+func compress(t *testing.T, format string, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	var err error
+	switch format {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "xz":
+		w, err = xz.NewWriter(&buf)
+	case "zstd":
+		w, err = zstd.NewWriter(&buf)
+	case "tar":
+		return data
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecompress(t *testing.T) {
+	want := []byte("index.plist e companhia\n")
+	for _, format := range []string{"gzip", "xz", "zstd", "tar"} {
+		r, err := Decompress(bytes.NewReader(compress(t, format, want)))
+		if err != nil {
+			t.Errorf("%s: %v", format, err)
+			continue
+		}
+		got, err := io.ReadAll(r)
+		r.Close()
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("%s: leu %q (%v), quer %q", format, got, err, want)
+		}
+	}
+}
+
+func testIndex(t *testing.T, pkgs map[string]map[string]interface{}) []byte {
+	t.Helper()
+	data, err := plist.Marshal(pkgs, plist.XMLFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func testRepodata(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range []string{"index.plist", "index-meta.plist", "stage.plist"} {
+		data, ok := files[name]
+		if !ok {
+			continue
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data))}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(data)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestOpen(t *testing.T) {
+	const uri = "https://repo-default.voidlinux.org/current"
+	index := testIndex(t, map[string]map[string]interface{}{
+		"firefox": {
+			"pkgver":         "firefox-128.0_1",
+			"short_desc":     "Mozilla Firefox web browser",
+			"architecture":   "x86_64",
+			"run_depends":    []string{"glibc>=2.36_1", "gtk+3>=3.24.0_1"},
+			"filename-size":  int64(80 << 20),
+			"installed_size": int64(250 << 20),
+			"license":        "MPL-2.0",
+		},
+		"glibc": {"pkgver": "glibc-2.39_2", "short_desc": "GNU C library"},
+	})
+	stage := testIndex(t, map[string]map[string]interface{}{
+		"glibc": {"pkgver": "glibc-2.40_1"},
+	})
+	meta := testIndex(t, map[string]map[string]interface{}{
+		"public-key": {"size": int64(4096)},
+	})
+
+	dir := t.TempDir()
+	for _, format := range []string{"gzip", "xz", "zstd", "tar"} {
+		path := filepath.Join(dir, format+"-repodata")
+		tarball := testRepodata(t, map[string][]byte{"index.plist": index, "stage.plist": stage, "index-meta.plist": meta})
+		if err := os.WriteFile(path, compress(t, format, tarball), 0644); err != nil {
+			t.Fatal(err)
+		}
+		repo, err := Open(path, uri)
+		if err != nil {
+			t.Errorf("%s: %v", format, err)
+			continue
+		}
+		ff := repo.Packages["firefox"]
+		if len(repo.Packages) != 2 || ff == nil {
+			t.Errorf("%s: pacotes = %v", format, repo.Packages)
+			continue
+		}
+		if ff.Name != "firefox" || ff.Repo != uri || ff.Pkgver != "firefox-128.0_1" ||
+			ff.InstalledSize != 250<<20 || len(ff.RunDepends) != 2 || ff.License != "MPL-2.0" {
+			t.Errorf("%s: firefox = %+v", format, ff)
+		}
+		if g := repo.Stage["glibc"]; g == nil || g.Pkgver != "glibc-2.40_1" {
+			t.Errorf("%s: stage = %v", format, repo.Stage)
+		}
+		if repo.Meta["public-key"] == nil {
+			t.Errorf("%s: meta = %v", format, repo.Meta)
+		}
+	}
+
+	// sem index.plist não há repositório
+	path := filepath.Join(dir, "vazio-repodata")
+	os.WriteFile(path, testRepodata(t, map[string][]byte{"index-meta.plist": meta}), 0644)
+	if _, err := Open(path, uri); err == nil {
+		t.Error("Open sem index.plist não falhou")
+	}
+	if _, err := Open(filepath.Join(dir, "nada"), uri); !os.IsNotExist(err) {
+		t.Errorf("Open de arquivo inexistente: %v", err)
+	}
+}
+
+func TestDecodeIndex(t *testing.T) {
+	index, err := DecodeIndex([]byte("  \n"), "x")
+	if err != nil || len(index) != 0 {
+		t.Errorf("stage.plist vazio: %v, %v", index, err)
+	}
+	if _, err := DecodeIndex([]byte("<plist><dict><key>"), "x"); err == nil {
+		t.Error("plist truncado não falhou")
+	}
+}
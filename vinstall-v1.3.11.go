@@ -16,22 +16,40 @@
 package main
 
 import (
+	"archive/tar"
 	"bufio"
 	"bytes"
 	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"debug/elf"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/voidlinuxbr/voidbr-vinstall/repodata"
 	"howett.net/plist"
 	"io"
+	"math"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"reflect"
+	"regexp"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
+	"unicode"
+	"unicode/utf8"
 	"unsafe"
 
 	"github.com/fatih/color"
@@ -67,6 +85,7 @@ type winsize struct {
 
 type Package struct {
 	Status        string
+	Installed     bool
 	FullName      string
 	Description   string
 	Maintainer    string
@@ -85,12 +104,19 @@ func main() {
 	var targets []string
 	mode := "install"
 	searchRemote := false
+	previewOnly := false
+	installFound := false
+	hookShell := ""
+	rollbackTo := ""
+	policy := cachePolicy{Keep: -1}
+	reverse, installedOnly := false, false
+	treeDepth := 0
+	var history historyFilter
+	var historyArgs [][2]string
 	filter := ""
 
-	fmt.Print("\033[36m")
-	defer fmt.Print("\033[0m")
-
-	for _, arg := range args {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
 		switch arg {
 		case "-h", "--help":
 			printUsage()
@@ -100,8 +126,107 @@ func main() {
 			return
 		case "--history":
 			mode = "history"
+		case "--hold":
+			mode = "hold"
+		case "--unhold":
+			mode = "unhold"
+		case "--holds":
+			mode = "holds"
+		case "--explicit":
+			mode = "explicit"
+		case "--mark-manual":
+			mode = "mark-manual"
+		case "--mark-auto":
+			mode = "mark-auto"
+		case "--why":
+			mode = "why"
+		case "--updates":
+			mode = "updates"
+		case "--tree":
+			mode = "tree"
+		case "--reverse":
+			reverse = true
+		case "--installed":
+			installedOnly = true
+		case "--depth":
+			if i+1 < len(args) {
+				i++
+				n, err := strconv.Atoi(args[i])
+				if err != nil || n < 0 {
+					fmt.Fprintf(os.Stderr, "%s %s %s\n", red("[!]"), white("--depth inválido:"), yellow(args[i]))
+					os.Exit(1)
+				}
+				treeDepth = n
+			}
+		case "--rollback":
+			mode = "rollback"
+			if i+1 < len(args) {
+				i++
+				rollbackTo = args[i]
+			}
+		case "--since", "--until", "--pkg", "--action":
+			if i+1 < len(args) {
+				i++
+				historyArgs = append(historyArgs, [2]string{arg, args[i]})
+			}
+		case "--tui":
+			mode = "tui"
+		case "--preview":
+			previewOnly = true
+		case "--check-conflicts":
+			mode = "check-conflicts"
+		case "--check-shlibs":
+			mode = "check-shlibs"
+		case "--verify":
+			mode = "verify"
+		case "--apply":
+			mode = "apply"
+		case "--export":
+			mode = "export"
+		case "--install":
+			installFound = true
+		case "--cnf-hook":
+			mode = "cnf-hook"
+			if i+1 < len(args) {
+				i++
+				hookShell = args[i]
+			}
+		case "--update-file-index":
+			mode = "update-file-index"
+		case "--arch":
+			if i+1 >= len(args) || strings.HasPrefix(args[i+1], "-") {
+				fmt.Fprintf(os.Stderr, "%s %s\n", red("[!]"), white("--arch exige uma arquitetura (ex: --arch aarch64)"))
+				os.Exit(1)
+			}
+			i++
+			targetArch = args[i]
+		case "-r", "--rootdir":
+			if i+1 < len(args) {
+				i++
+				rootDir = args[i]
+				flags = append(flags, "-r", rootDir)
+			}
+		case "--output":
+			if i+1 < len(args) {
+				i++
+				outputFormat = args[i]
+			}
 		case "-Scc":
 			mode = "clean"
+		case "--all":
+			policy.All = true
+		case "--uninstalled-only":
+			policy.UninstalledOn = true
+		case "--dry-run":
+			policy.DryRun = true
+		case "--keep", "--older-than", "--max-size":
+			if i+1 < len(args) {
+				i++
+				if err := policy.set(arg, args[i]); err != nil {
+					fmt.Fprintf(os.Stderr, "%s %v\n", red("[!]"), err)
+					os.Exit(1)
+				}
+			}
 		case "-X", "-x":
 			mode = "remove"
 		case "-F":
@@ -119,6 +244,9 @@ func main() {
 			mode = "remote-search"
 		case "-Sss":
 			mode = "remote-search-detailed"
+			terms, opts := splitQueryArgs(args[i+1:])
+			targets = append(targets, terms...)
+			args = append(args[:i+1], opts...)
 		case "-Ssi":
 			mode = "remote-search"
 			filter = "installed"
@@ -126,7 +254,19 @@ func main() {
 			mode = "remote-search"
 			filter = "missing"
 		default:
-			if strings.HasPrefix(arg, "-Q") {
+			if strings.HasPrefix(arg, "--arch=") {
+				if targetArch = strings.TrimPrefix(arg, "--arch="); targetArch == "" {
+					fmt.Fprintf(os.Stderr, "%s %s\n", red("[!]"), white("--arch exige uma arquitetura (ex: --arch=aarch64)"))
+					os.Exit(1)
+				}
+			} else if strings.HasPrefix(arg, "--rootdir=") {
+				rootDir = strings.TrimPrefix(arg, "--rootdir=")
+				flags = append(flags, "-r", rootDir)
+			} else if strings.HasPrefix(arg, "--output=") {
+				outputFormat = strings.TrimPrefix(arg, "--output=")
+			} else if opt, val, ok := strings.Cut(arg, "="); ok && (opt == "--since" || opt == "--until" || opt == "--pkg" || opt == "--action") {
+				historyArgs = append(historyArgs, [2]string{opt, val})
+			} else if strings.HasPrefix(arg, "-Q") {
 				mode = "query-generic"
 				filter = strings.Replace(arg, "-Q", "-", 1)
 			} else if strings.HasPrefix(arg, "-") {
@@ -136,14 +276,116 @@ func main() {
 			}
 		}
 	}
+
+	switch {
+	case outputFormat == "plain", outputFormat == "json", outputFormat == "tsv":
+	case outputFormat == "dot" && mode == "tree":
+	default:
+		fmt.Fprintf(os.Stderr, "%s %s %s\n", red("[!]"), white("Formato de saída inválido:"), yellow(outputFormat))
+		os.Exit(1)
+	}
+	for _, kv := range historyArgs {
+		var err error
+		switch kv[0] {
+		case "--since":
+			history.Since, err = parseHistoryTime(kv[1], false)
+		case "--until":
+			history.Until, err = parseHistoryTime(kv[1], true)
+		case "--pkg":
+			history.Pkg = kv[1]
+		case "--action":
+			history.Action = kv[1]
+			if history.Action != "install" && history.Action != "update" && history.Action != "remove" {
+				err = fmt.Errorf("ação inválida: %q (install, update ou remove)", kv[1])
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s %v\n", red("[!]"), err)
+			os.Exit(1)
+		}
+	}
+	if outputFormat != "plain" || os.Getenv("NO_COLOR") != "" {
+		color.NoColor = true
+	}
+	if !color.NoColor {
+		fmt.Print("\033[36m")
+		defer fmt.Print("\033[0m")
+	}
+
 	switch mode {
+	case "tui":
+		runTUI(strings.Join(targets, " "), flags)
+	case "check-conflicts":
+		if len(targets) > 0 {
+			checkConflicts(targets)
+		}
+	case "check-shlibs":
+		if !checkShlibs() {
+			os.Exit(1)
+		}
+	case "apply":
+		if len(targets) == 0 || !applyManifest(targets[0], flags) {
+			os.Exit(1)
+		}
+	case "export":
+		path := ""
+		if len(targets) > 0 {
+			path = targets[0]
+		}
+		if !exportManifest(path) {
+			os.Exit(1)
+		}
+	case "verify":
+		if !verifyPackages(targets, flags) {
+			os.Exit(1)
+		}
+	case "hold", "unhold":
+		if len(targets) > 0 && !setHolds(targets, mode == "hold") {
+			os.Exit(1)
+		}
+	case "holds":
+		showHolds()
+	case "explicit":
+		listExplicit()
+	case "mark-manual", "mark-auto":
+		if len(targets) > 0 && !markPackages(targets, strings.TrimPrefix(mode, "mark-")) {
+			os.Exit(1)
+		}
+	case "why":
+		if len(targets) > 0 {
+			showWhy(targets)
+		}
+	case "updates":
+		showUpdates()
+	case "tree":
+		if len(targets) > 0 {
+			showTree(targets, reverse, installedOnly, treeDepth)
+		}
+	case "rollback":
+		if !rollback(rollbackTo, flags) {
+			os.Exit(1)
+		}
+	case "update-file-index":
+		updateFileIndex(targets)
 	case "history":
-		showHistory()
+		showHistory(history)
 	case "clean":
-		cleanXbpsCache()
+		cleanXbpsCache(policy)
+	case "cnf-hook":
+		if !printCnfHook(hookShell) {
+			os.Exit(1)
+		}
 	case "find":
-		if len(targets) > 0 {
-			findProvides(targets[0], searchRemote)
+		if installFound {
+			for _, t := range targets {
+				if !installCommandProvider(t, flags) {
+					os.Exit(1)
+				}
+			}
+			return
+		}
+		for _, t := range targets {
+			findProvides(t, searchRemote)
 		}
 	case "list-installed":
 		listLocal("installed", "")
@@ -158,16 +400,18 @@ func main() {
 			pkgs := fetchSuggestions(targets[0])
 			pkgs = uniquePackagesExact(pkgs)
 			sort.Slice(pkgs, func(i, j int) bool {
-				return pkgs[i].FullName < pkgs[j].FullName
+				return lessPkgver(pkgs[i].FullName, pkgs[j].FullName)
 			})
 			if filter != "" {
 				pkgs = filterPackages(pkgs, filter)
 			}
-			displaySearch(pkgs, "Resultados (Rápido):")
+			if !writeRecords(packageRecords(pkgs)) {
+				displaySearch(pkgs, "Resultados (Rápido):")
+			}
 		}
 	case "remote-search-detailed":
 		if len(targets) > 0 {
-			remoteSearchDetailed(targets[0])
+			remoteSearchDetailed(strings.Join(targets, " "))
 		}
 	case "remove":
 		if len(targets) > 0 {
@@ -176,28 +420,53 @@ func main() {
 	case "query-generic":
 		runBinary("xbps-query", []string{filter}, targets)
 	default:
-		for _, f := range flags {
-			if strings.Contains(f, "u") {
-				flags = append(flags, "--ignore-file-conflicts")
-				break
+		if previewOnly {
+			showTransactionPreview(targets, flags, true)
+			return
+		}
+		// No -Su com pins o sync vem primeiro: prévia e pins precisam ver o
+		// repodata novo, senão uma versão recém-publicada escapa do pin.
+		if hasShortFlag(flags, 'S') && hasShortFlag(flags, 'u') && len(readPins()) > 0 {
+			if !runBinary("xbps-install", withoutShortFlag(flags, 'u'), []string{}) {
+				os.Exit(1)
 			}
+			flags = withoutShortFlag(flags, 'S')
+		}
+		if outputFormat == "plain" && (len(targets) > 0 || hasShortFlag(flags, 'u')) {
+			showTransactionPreview(targets, flags, false)
 		}
 
-		if len(targets) > 0 {
-			flags = append(flags, "--ignore-file-conflicts")
-			if !runBinary("xbps-install", flags, targets) {
-				suggestions := fetchSuggestions(targets[0])
-				if len(suggestions) > 0 {
-					displayMenu(suggestions, flags)
+		var held []holdRecord
+		var pinned []string
+		if hasShortFlag(flags, 'u') {
+			held = holdRecords(loadPackageIndex(repoArch()), mustReadPkgdb(), true)
+			for _, r := range held {
+				if r.Rule != "hold" {
+					pinned = append(pinned, r.Name)
 				}
-			} else {
-				for _, t := range targets {
-					checkAndEnableService(t)
+			}
+		}
+		ok := withTempHolds(pinned, func() {
+			if len(targets) > 0 {
+				if !runBinary("xbps-install", flags, targets) {
+					suggestions := suggestPackages(targets[0])
+					if len(suggestions) > 0 {
+						displayMenu(suggestions, flags)
+					}
+				} else {
+					for _, t := range targets {
+						checkAndEnableService(t)
+					}
 				}
+			} else if len(flags) > 0 {
+				runBinary("xbps-install", flags, []string{})
 			}
-		} else if len(flags) > 0 {
-			runBinary("xbps-install", flags, []string{})
+		})
+		if !ok {
+			fmt.Fprintf(os.Stderr, "%s %s %s\n", red("[!]"), white("Não foi possível reter os pacotes com pin; nada foi instalado:"), yellow(strings.Join(pinned, " ")))
+			os.Exit(1)
 		}
+		reportHeld(held)
 	}
 }
 
@@ -217,30 +486,85 @@ func formatBytes(b int64) string {
 }
 
 func getTerminalWidth() int {
+	_, cols := getTerminalSize()
+	return cols
+}
+
+func getTerminalSize() (rows, cols int) {
 	ws := &winsize{}
 	retCode, _, _ := syscall.Syscall(syscall.SYS_IOCTL,
 		uintptr(syscall.Stdin),
 		uintptr(syscall.TIOCGWINSZ),
 		uintptr(unsafe.Pointer(ws)))
 
-	if int(retCode) == 0 {
-		return int(ws.Col)
+	if int(retCode) == 0 && ws.Col > 0 {
+		return int(ws.Row), int(ws.Col)
+	}
+	return 24, 80
+}
+
+// hasShortFlag indica se alguma flag curta do xbps (ex: -Syu) contém c.
+func hasShortFlag(flags []string, c byte) bool {
+	for _, f := range flags {
+		if strings.HasPrefix(f, "-") && !strings.HasPrefix(f, "--") && strings.IndexByte(f[1:], c) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutShortFlag tira a letra c dos grupos de flags curtas ("-Syu" sem
+// 'S' vira "-yu"); grupos que ficam vazios somem.
+func withoutShortFlag(flags []string, c byte) []string {
+	var out []string
+	for _, f := range flags {
+		if strings.HasPrefix(f, "-") && !strings.HasPrefix(f, "--") {
+			f = "-" + strings.ReplaceAll(f[1:], string(c), "")
+			if f == "-" {
+				continue
+			}
+		}
+		out = append(out, f)
 	}
-	return 80
+	return out
 }
 
-func cleanVersion(fullName string) string {
-	if i := strings.LastIndex(fullName, "-"); i != -1 {
-		return fullName[:i]
+// removeFlags reduz as flags do xbps-install às que valem para o
+// xbps-remove: -y e -r <rootdir>. -S, -u, -f e afins ficam de fora.
+func removeFlags(flags []string) []string {
+	var out []string
+	yes := false
+	for i := 0; i < len(flags); i++ {
+		f := flags[i]
+		switch {
+		case f == "-r" && i+1 < len(flags):
+			out = append(out, f, flags[i+1])
+			i++
+		case f == "--yes", strings.HasPrefix(f, "-") && !strings.HasPrefix(f, "--") && strings.IndexByte(f[1:], 'y') >= 0:
+			if !yes {
+				out = append(out, "-y")
+				yes = true
+			}
+		}
 	}
-	return fullName
+	return out
 }
 
 func runBinary(bin string, flags []string, pkgs []string) bool {
+	crossArch := targetArch != "" && archBinaries[bin]
+	if crossArch && bin == "xbps-install" && rootDir == "" && targetArch != repodata.NativeArch() {
+		fmt.Fprintf(os.Stderr, "%s %s %s %s\n", red("[!]"), white("Pacotes"), yellow(targetArch), white("não podem ir para o / deste sistema; use -r <rootdir>."))
+		return false
+	}
 	fmt.Printf("%s %s %s %s\n", cyan(">>>"), cyan(bin), yellow(fmt.Sprint(flags)), magenta(fmt.Sprint(pkgs)))
-	fmt.Print("\033[36m")
-	defer fmt.Print("\033[0m")
+	if !color.NoColor {
+		fmt.Print("\033[36m")
+		defer fmt.Print("\033[0m")
+	}
 	params := []string{bin}
+	if crossArch {
+		params = append([]string{"env", "XBPS_ARCH=" + targetArch}, params...)
+	}
 	params = append(params, flags...)
 	params = append(params, pkgs...)
 	cmd := exec.Command("sudo", params...)
@@ -253,6 +577,7 @@ func runBinary(bin string, flags []string, pkgs []string) bool {
 			if status, ok := exitError.Sys().(syscall.WaitStatus); ok {
 				if status.Signaled() && status.Signal() == syscall.SIGINT {
 					fmt.Printf("\n%s %s\n", red("[!]"), white("Operação cancelada pelo usuário."))
+					releaseTempHolds()
 					os.Exit(1)
 				}
 			}
@@ -262,21 +587,287 @@ func runBinary(bin string, flags []string, pkgs []string) bool {
 	return true
 }
 
-// --- FUNÇÕES DE BUSCA DETALHADA E UTILITÁRIOS (INTACTOS) ---
+// --- VERSÕES (xbps_cmpver e pkgpattern) ---
+
+// patternName extrai o nome do pacote de uma dependência do xbps, seja um
+// padrão ("glibc>=2.36_1", "foo-1.[0-9]*") ou um pkgver ("foo-1.0_1").
+func patternName(pattern string) string {
+	if i := strings.IndexAny(pattern, "<>="); i > 0 {
+		return pattern[:i]
+	}
+	if i := strings.IndexAny(pattern, "*?["); i > 0 {
+		if j := strings.LastIndex(pattern[:i], "-"); j > 0 {
+			return pattern[:j]
+		}
+	}
+	if i := strings.LastIndex(pattern, "-"); i > 0 && strings.Contains(pattern[i+1:], "_") {
+		return pattern[:i]
+	}
+	return pattern
+}
+
+// Valores dos modificadores do dewey.c do xbps: ficam abaixo de Dot, então
+// "1.0rc1" < "1.0" < "1.0.1".
+const (
+	deweyAlpha = -3
+	deweyBeta  = -2
+	deweyRC    = -1
+	deweyDot   = 0
+)
+
+var deweyModifiers = []struct {
+	text  string
+	value int
+}{
+	{"alpha", deweyAlpha},
+	{"beta", deweyBeta},
+	{"pre", deweyRC},
+	{"rc", deweyRC},
+	{"pl", deweyDot},
+	{".", deweyDot},
+}
+
+type deweyVersion struct {
+	parts    []int
+	revision int
+}
+
+// parseDewey reproduz o mkversion do xbps: números viram componentes, "."
+// e os modificadores viram Dot/Alpha/Beta/RC, uma letra solta vira Dot
+// seguido da posição no alfabeto e "_N" é a revisão, comparada por último.
+func parseDewey(version string) deweyVersion {
+	var d deweyVersion
+	isDigit := func(i int) bool { return i < len(version) && version[i] >= '0' && version[i] <= '9' }
+	number := func(i int) (int, int) {
+		n := 0
+		for ; isDigit(i); i++ {
+			n = n*10 + int(version[i]-'0')
+		}
+		return n, i
+	}
+	for i := 0; i < len(version); {
+		if isDigit(i) {
+			var n int
+			n, i = number(i)
+			d.parts = append(d.parts, n)
+			continue
+		}
+		if version[i] == '_' {
+			i++
+			if isDigit(i) {
+				d.revision, i = number(i)
+			}
+			continue
+		}
+		matched := false
+		for _, m := range deweyModifiers {
+			if len(version)-i >= len(m.text) && strings.EqualFold(version[i:i+len(m.text)], m.text) {
+				d.parts = append(d.parts, m.value)
+				i += len(m.text)
+				matched = true
+				break
+			}
+		}
+		if matched {
+			continue
+		}
+		if c := version[i] | 0x20; c >= 'a' && c <= 'z' {
+			d.parts = append(d.parts, deweyDot, int(c-'a')+1)
+		}
+		i++
+	}
+	return d
+}
+
+// cmpVersion é o xbps_cmpver: aceita versões ("1.2_1") ou pkgvers
+// ("foo-1.2_1") e devolve -1, 0 ou 1. Componentes ausentes valem 0.
+func cmpVersion(a, b string) int {
+	if i := strings.LastIndexByte(a, '-'); i >= 0 {
+		a = a[i+1:]
+	}
+	if i := strings.LastIndexByte(b, '-'); i >= 0 {
+		b = b[i+1:]
+	}
+	da, db := parseDewey(a), parseDewey(b)
+	for i := 0; i < len(da.parts) || i < len(db.parts); i++ {
+		var x, y int
+		if i < len(da.parts) {
+			x = da.parts[i]
+		}
+		if i < len(db.parts) {
+			y = db.parts[i]
+		}
+		if x != y {
+			return sign(x - y)
+		}
+	}
+	return sign(da.revision - db.revision)
+}
 
-func toInt64(v interface{}) int64 {
-	val := reflect.ValueOf(v)
-	switch val.Kind() {
-	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		return val.Int()
-	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		return int64(val.Uint())
-	case reflect.Float32, reflect.Float64:
-		return int64(val.Float())
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
 	}
 	return 0
 }
 
+// lessPkgver ordena por nome e, dentro do mesmo nome, pela versão.
+func lessPkgver(a, b string) bool {
+	na, nb := patternName(a), patternName(b)
+	if na != nb {
+		return na < nb
+	}
+	return cmpVersion(a, b) < 0
+}
+
+// pkgpatternMatch é o xbps_pkgpattern_match: igualdade exata, faixas
+// ("foo>=1.2_1", "foo>=1.0<2.0") ou glob no pkgver ("foo-1.[0-9]*").
+func pkgpatternMatch(pkgver, pattern string) bool {
+	if pkgver == pattern {
+		return true
+	}
+	if strings.ContainsAny(pattern, "<>") {
+		return deweyMatch(pkgver, pattern)
+	}
+	if strings.ContainsAny(pattern, "*?[]") {
+		ok, _ := filepath.Match(strings.ReplaceAll(pattern, "[!", "[^"), pkgver)
+		return ok
+	}
+	return false
+}
+
+// deweyMatch segue o dewey_match do xbps: o nome precisa ser idêntico e um
+// limite inferior (> ou >=) pode vir seguido de um superior (< ou <=).
+func deweyMatch(pkgver, pattern string) bool {
+	dash := strings.LastIndexByte(pkgver, '-')
+	sep := strings.IndexAny(pattern, "<>")
+	if dash < 0 || pkgver[:dash] != pattern[:sep] {
+		return false
+	}
+	version := pkgver[dash+1:]
+	test := func(expr string) (bool, int) {
+		op := expr[:1]
+		if len(expr) > 1 && expr[1] == '=' {
+			op = expr[:2]
+		}
+		limit := expr[len(op):]
+		if i := strings.IndexByte(limit, '<'); i >= 0 && op[0] == '>' {
+			limit = limit[:i]
+		}
+		c := cmpVersion(version, limit)
+		switch op {
+		case "<":
+			return c < 0, len(op) + len(limit)
+		case "<=":
+			return c <= 0, len(op) + len(limit)
+		case ">":
+			return c > 0, len(op) + len(limit)
+		}
+		return c >= 0, len(op) + len(limit)
+	}
+	ok, n := test(pattern[sep:])
+	if rest := pattern[sep+n:]; ok && rest != "" {
+		ok, _ = test(rest)
+	}
+	return ok
+}
+
+// --- SAÍDA ESTRUTURADA (--output) ---
+
+// outputFormat é definido por --output: plain (padrão, colorido), json ou tsv.
+var outputFormat = "plain"
+
+// packageRecord é a forma estável de Package em --output json/tsv.
+type packageRecord struct {
+	Status        string `json:"status"`
+	Pkgver        string `json:"pkgver"`
+	Name          string `json:"name"`
+	Version       string `json:"version"`
+	Description   string `json:"description"`
+	Maintainer    string `json:"maintainer"`
+	Repo          string `json:"repo"`
+	SizeDownload  int64  `json:"download_size"`
+	SizeInstalled int64  `json:"installed_size"`
+}
+
+func packageRecords(pkgs []Package) []packageRecord {
+	records := make([]packageRecord, 0, len(pkgs))
+	for _, p := range pkgs {
+		status := "available"
+		if p.Installed {
+			status = "installed"
+		}
+		name := patternName(p.FullName)
+		records = append(records, packageRecord{
+			Status:        status,
+			Pkgver:        p.FullName,
+			Name:          name,
+			Version:       strings.TrimPrefix(strings.TrimPrefix(p.FullName, name), "-"),
+			Description:   p.Description,
+			Maintainer:    p.Maintainer,
+			Repo:          p.Repo,
+			SizeDownload:  p.SizeDownload,
+			SizeInstalled: p.SizeInstalled,
+		})
+	}
+	return records
+}
+
+// writeRecords imprime uma slice de structs no formato de --output. Em json,
+// a slice vira um array; em tsv, a primeira linha traz as tags json como
+// cabeçalho. No modo plain devolve false e a exibição fica com o chamador.
+func writeRecords(records interface{}) bool {
+	switch outputFormat {
+	case "json":
+		v := reflect.ValueOf(records)
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			records = []struct{}{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		enc.Encode(records)
+		return true
+	case "tsv":
+		w := bufio.NewWriter(os.Stdout)
+		defer w.Flush()
+		v := reflect.ValueOf(records)
+		elem := v.Type().Elem()
+		if elem.Kind() == reflect.Ptr {
+			elem = elem.Elem()
+		}
+		var header []string
+		for i := 0; i < elem.NumField(); i++ {
+			name, _, _ := strings.Cut(elem.Field(i).Tag.Get("json"), ",")
+			header = append(header, name)
+		}
+		fmt.Fprintln(w, strings.Join(header, "\t"))
+		clean := strings.NewReplacer("\t", " ", "\n", " ", "\r", " ")
+		for i := 0; i < v.Len(); i++ {
+			row := reflect.Indirect(v.Index(i))
+			if !row.IsValid() {
+				continue
+			}
+			cols := make([]string, row.NumField())
+			for j := range cols {
+				val := row.Field(j).Interface()
+				if t, ok := val.(time.Time); ok {
+					val = t.Format(time.RFC3339)
+				}
+				cols[j] = clean.Replace(fmt.Sprint(val))
+			}
+			fmt.Fprintln(w, strings.Join(cols, "\t"))
+		}
+		return true
+	}
+	return false
+}
+
+// --- FUNÇÕES DE BUSCA DETALHADA E UTILITÁRIOS (INTACTOS) ---
+
 func truncate(s string, max int) string {
 	if max < 3 {
 		return ""
@@ -300,113 +891,59 @@ func printPackage(w *bufio.Writer, i int, p Package, width int) {
 
 func getInstalledPackages() map[string]bool {
 	installed := make(map[string]bool)
-	out, _ := exec.Command("xbps-query", "-l").Output()
-	for _, line := range strings.Split(string(out), "\n") {
-		fields := strings.Fields(line)
-		if len(fields) >= 2 {
-			installed[fields[1]] = true
+	if pkgdb, err := readPkgdb(); err == nil {
+		for _, entry := range pkgdb {
+			installed[entry.Pkgver] = true
 		}
+		return installed
 	}
-	return installed
-}
-
-func getActiveRepos() map[string]string {
-	repoMap := make(map[string]string)
-	out, err := exec.Command("xbps-query", "-L").Output()
-	if err != nil {
-		return repoMap
+	args := []string{"-l"}
+	if rootDir != "" {
+		args = append(args, "-r", rootDir)
 	}
+	out, _ := exec.Command("xbps-query", args...).Output()
 	for _, line := range strings.Split(string(out), "\n") {
 		fields := strings.Fields(line)
 		if len(fields) >= 2 {
-			repoMap[strings.NewReplacer(":", "_", "/", "_", ".", "_").Replace(fields[1])] = fields[1]
+			installed[fields[1]] = true
 		}
 	}
-	return repoMap
+	return installed
 }
 
 func remoteSearchDetailed(query string) {
-	repoMap := getActiveRepos()
+	expr, err := parseQuery(query)
+	if err != nil {
+		fmt.Printf("%s %s %v\n", red("[!]"), white("Consulta inválida:"), err)
+		return
+	}
+	index := loadPackageIndex(repoArch())
 	installed := getInstalledPackages()
+	installedNames := make(map[string]bool)
+	for pkgver := range installed {
+		installedNames[patternName(pkgver)] = true
+	}
 	var pkgs []Package
-	var mu sync.Mutex
-	var wg sync.WaitGroup
-
-	queryLower := strings.ToLower(query)
-	queryClean := strings.ReplaceAll(queryLower, " ", "")
 
-	for dirName, repoURL := range repoMap {
-		repoPath := filepath.Join("/var/db/xbps/", dirName, "x86_64-repodata")
-		if _, err := os.Stat(repoPath); os.IsNotExist(err) {
+	for _, pkg := range index.All() {
+		if !expr.match(pkg, installedNames[pkg.Name]) {
 			continue
 		}
-
-		wg.Add(1)
-		go func(p, url string) {
-			defer wg.Done()
-			file, err := os.Open(p)
-			if err != nil {
-				return
-			}
-			defer file.Close()
-			reader, err := zstd.NewReader(file)
-			if err != nil {
-				return
-			}
-			defer reader.Close()
-			data, err := io.ReadAll(reader)
-			if err != nil || len(data) <= 512 {
-				return
-			}
-			var index map[string]interface{}
-			if err := plist.NewDecoder(bytes.NewReader(data[512:])).Decode(&index); err != nil {
-				return
-			}
-
-			var local []Package
-			for _, pkgData := range index {
-				pkg, ok := pkgData.(map[string]interface{})
-				if !ok {
-					continue
-				}
-
-				sizeDown := toInt64(pkg["filename-size"])
-				sizeInst := toInt64(pkg["installed_size"])
-
-				pkgVerStr := fmt.Sprintf("%v", pkg["pkgver"])
-				fullText := pkgVerStr + " " + fmt.Sprint(pkg["short_desc"]) + " " +
-					fmt.Sprint(pkg["maintainer"]) + " " + url
-
-				fullTextClean := strings.ToLower(strings.ReplaceAll(fullText, " ", ""))
-
-				if strings.Contains(fullTextClean, queryClean) {
-					status := red("[-]")
-					if installed[pkgVerStr] {
-						status = green("[✔]")
-					}
-					local = append(local, Package{
-						Status:        status,
-						FullName:      pkgVerStr,
-						Description:   fmt.Sprint(pkg["short_desc"]),
-						Maintainer:    fmt.Sprintf("%v", pkg["maintainer"]),
-						Repo:          url,
-						SizeDownload:  sizeDown,
-						SizeInstalled: sizeInst,
-					})
-				}
-			}
-
-			if len(local) > 0 {
-				mu.Lock()
-				pkgs = append(pkgs, local...)
-				mu.Unlock()
-			}
-		}(repoPath, repoURL)
+		pkgs = append(pkgs, newSearchPackage(pkg, installed))
 	}
-	wg.Wait()
-	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].FullName < pkgs[j].FullName })
+	sort.Slice(pkgs, func(i, j int) bool { return lessPkgver(pkgs[i].FullName, pkgs[j].FullName) })
 
-	if len(pkgs) == 0 {
+	if len(index.Repos) == 0 {
+		arch := repoArch()
+		fmt.Fprintf(os.Stderr, "%s %s %s\n", red("[!]"), white("Nenhum repodata encontrado para"), yellow(arch))
+		fmt.Fprintf(os.Stderr, "%s %s\n", yellow("[TIP]"), white("Sincronize com 'XBPS_ARCH="+arch+" xbps-install -S'."))
+	}
+	if writeRecords(packageRecords(pkgs)) {
+		return
+	}
+	if len(index.Repos) == 0 {
+		return
+	} else if len(pkgs) == 0 {
 		fmt.Println("Nenhum pacote encontrado.")
 	} else {
 		fmt.Printf("\n%s\n", cyan("Resultados encontrados nos repositórios:"))
@@ -421,370 +958,5226 @@ func remoteSearchDetailed(query string) {
 	}
 }
 
-// --- FUNÇÕES DE BUSCA RÁPIDA E OUTROS ---
+// --- ARQUITETURA ---
 
-func fetchSuggestions(query string) []Package {
-	ctx, cancel := context.WithTimeout(context.Background(), execTimeout)
-	defer cancel()
-	cmd := exec.CommandContext(ctx, "xbps-query", "-Rs", query)
-	out, _ := cmd.Output()
-	var pkgs []Package
-	for _, line := range strings.Split(string(out), "\n") {
-		if len(line) < 4 {
-			continue
-		}
-		status := line[0:4]
-		rest := strings.TrimSpace(line[4:])
-		name, desc, _ := strings.Cut(rest, " ")
-		if name != "" {
-			pkgs = append(pkgs, Package{Status: strings.TrimSpace(status), FullName: name, Description: strings.TrimSpace(desc)})
-		}
+// targetArch é a arquitetura estrangeira pedida via --arch (cross-install).
+var targetArch string
+
+// rootDir é o -r/--rootdir repassado ao xbps; cross-install só é aceito nele.
+var rootDir string
+
+// archBinaries são os binários que respeitam XBPS_ARCH. Os demais (remove,
+// pkgdb, reconfigure) operam no pkgdb do rootdir e não devem recebê-lo. Só o
+// xbps-install exige rootdir; consultas com --arch são sempre permitidas.
+var archBinaries = map[string]bool{"xbps-install": true, "xbps-query": true}
+
+// repoArch segue a precedência do xbps: --arch, XBPS_TARGET_ARCH e por fim a
+// arquitetura nativa.
+func repoArch() string {
+	if targetArch != "" {
+		return targetArch
 	}
-	return pkgs
+	if arch := os.Getenv("XBPS_TARGET_ARCH"); arch != "" {
+		return arch
+	}
+	return repodata.NativeArch()
 }
 
-func displaySearch(pkgs []Package, title string) {
-	if len(pkgs) == 0 {
-		return
+// --- PKGDB ---
+
+// metaDir é o /var/db/xbps do sistema alvo: o do rootdir quando há -r.
+func metaDir() string {
+	return filepath.Join(rootDir, "/var/db/xbps")
+}
+
+func pkgdbPath() string {
+	return filepath.Join(metaDir(), "pkgdb-0.38.plist")
+}
+
+// PkgdbEntry é o registro de um pacote instalado no pkgdb do xbps.
+type PkgdbEntry struct {
+	Name             string   `plist:"-"`
+	Pkgver           string   `plist:"pkgver"`
+	ShortDesc        string   `plist:"short_desc"`
+	Maintainer       string   `plist:"maintainer"`
+	License          string   `plist:"license"`
+	Homepage         string   `plist:"homepage"`
+	Repository       string   `plist:"repository"`
+	State            string   `plist:"state"`
+	AutomaticInstall bool     `plist:"automatic-install"`
+	InstallDate      string   `plist:"install-date"`
+	Hold             bool     `plist:"hold"`
+	InstalledSize    int64    `plist:"installed_size"`
+	RunDepends       []string `plist:"run_depends"`
+	Provides         []string `plist:"provides"`
+	ShlibProvides    []string `plist:"shlib-provides"`
+	ShlibRequires    []string `plist:"shlib-requires"`
+}
+
+func readPkgdb() (map[string]*PkgdbEntry, error) {
+	data, err := os.ReadFile(pkgdbPath())
+	if err != nil {
+		return nil, err
 	}
-	width := getTerminalWidth()
-	lineSeparator := white(strings.Repeat("─", width))
-	maxNameLen := 0
-	for _, p := range pkgs {
-		if len(p.FullName) > maxNameLen {
-			maxNameLen = len(p.FullName)
-		}
+	pkgdb := make(map[string]*PkgdbEntry)
+	if _, err := plist.Unmarshal(data, &pkgdb); err != nil {
+		return nil, fmt.Errorf("%s: %w", pkgdbPath(), err)
 	}
-	if maxNameLen > 50 {
-		maxNameLen = 50
+	for name, entry := range pkgdb {
+		// chaves internas como _XBPS_ALTERNATIVES_ não são pacotes
+		if strings.HasPrefix(name, "_XBPS_") || entry.Pkgver == "" {
+			delete(pkgdb, name)
+			continue
+		}
+		entry.Name = name
 	}
+	return pkgdb, nil
+}
 
-	w := bufio.NewWriter(os.Stdout)
-	defer w.Flush()
+// PkgFile é uma entrada de /var/db/xbps/.<pkgname>-files.plist. Target só
+// existe em links; Sha256 em arquivos comuns e conf_files.
+type PkgFile struct {
+	File   string `plist:"file"`
+	Sha256 string `plist:"sha256"`
+	Target string `plist:"target"`
+	Size   int64  `plist:"size"`
+	Mtime  int64  `plist:"mtime"`
+}
 
-	if title != "" {
-		fmt.Fprintf(w, "\n%s\n", cyan(title))
-	}
-	fmt.Fprintf(w, "%s\n", lineSeparator)
+// PkgFiles é a lista de arquivos gravada pelo xbps para cada pacote instalado.
+type PkgFiles struct {
+	Files     []PkgFile `plist:"files"`
+	Links     []PkgFile `plist:"links"`
+	ConfFiles []PkgFile `plist:"conf_files"`
+	Dirs      []PkgFile `plist:"dirs"`
+}
 
-	for i, p := range pkgs {
-		idx := yellow(fmt.Sprintf("[%2d]", i+1))
-		statusDisplay := "[-] "
-		statusColor := red(statusDisplay)
-		if strings.Contains(p.Status, "*") {
-			statusDisplay = "[✓] "
-			statusColor = green_bold(statusDisplay)
-		}
-		fmt.Fprintf(w, "%s %s %s  %s\n", idx, statusColor, white(fmt.Sprintf("%-*s", maxNameLen, p.FullName)), green(p.Description))
+func readPkgFiles(pkgname string) (*PkgFiles, error) {
+	path := filepath.Join(metaDir(), "."+pkgname+"-files.plist")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
 	}
-	fmt.Fprintln(w, lineSeparator)
+	var files PkgFiles
+	if _, err := plist.Unmarshal(data, &files); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &files, nil
 }
 
-func displayMenu(pkgs []Package, flags []string) {
-	if len(pkgs) == 0 {
-		return
+// --- ÍNDICE DE BUSCA ---
+
+// indexFormat muda sempre que a estrutura gravada em disco mudar, invalidando
+// os caches antigos.
+const indexFormat = 1
+
+// repoIndex é o cache de um arquivo de repodata, com a identidade da fonte
+// (tamanho, mtime e sha256) usada para invalidação.
+type repoIndex struct {
+	Format   int
+	URI      string
+	Source   string
+	Size     int64
+	ModTime  int64
+	Sha256   string
+	Packages []repodata.Package
+	Tokens   map[string][]int32
+}
+
+// PackageIndex reúne os índices de todos os repositórios ativos, na ordem de
+// prioridade do xbps.
+type PackageIndex struct {
+	Arch         string
+	Repos        []*repoIndex
+	BestMatching bool
+}
+
+func indexCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
 	}
-	displaySearch(pkgs, "\nSugestões encontradas no repositório:")
-	fmt.Printf("%s", yellow("Selecione o número para instalar ou 'q' para sair: "))
-	reader := bufio.NewReader(os.Stdin)
-	input, _ := reader.ReadString('\n')
-	input = strings.TrimSpace(input)
-	if input == "q" || input == "" {
-		return
+	return filepath.Join(dir, "vinstall")
+}
+
+func loadPackageIndex(arch string) *PackageIndex {
+	urls := repodata.Repositories()
+	index := &PackageIndex{Arch: arch}
+	for _, kv := range repodata.ReadConf() {
+		if kv.Key == "bestmatching" {
+			index.BestMatching = kv.Value == "true"
+		}
 	}
-	choice, _ := strconv.Atoi(input)
-	if choice > 0 && choice <= len(pkgs) {
-		name := cleanVersion(pkgs[choice-1].FullName)
-		if runBinary("xbps-install", flags, []string{name}) {
-			checkAndEnableService(name)
+	repos := make([]*repoIndex, len(urls))
+	var wg sync.WaitGroup
+	for i, url := range urls {
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+			ri, err := loadRepoIndex(url, arch)
+			if err != nil {
+				if !os.IsNotExist(err) {
+					fmt.Fprintf(os.Stderr, "%s %v\n", yellow("[!]"), err)
+				}
+				return
+			}
+			repos[i] = ri
+		}(i, url)
+	}
+	wg.Wait()
+	for _, ri := range repos {
+		if ri != nil {
+			index.Repos = append(index.Repos, ri)
 		}
 	}
+	return index
 }
 
-func uniquePackagesExact(pkgs []Package) []Package {
-	keys := make(map[string]bool)
-	var list []Package
-	for _, p := range pkgs {
-		if !keys[p.FullName] {
-			keys[p.FullName] = true
-			list = append(list, p)
+// loadRepoIndex usa o cache enquanto tamanho e mtime do repodata baterem. Se
+// mudarem mas o sha256 for o mesmo (ex: arquivo apenas tocado), só a
+// identidade é atualizada; caso contrário o índice é reconstruído.
+func loadRepoIndex(uri, arch string) (*repoIndex, error) {
+	source := repodata.Path(uri, arch)
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, err
+	}
+	cacheFile := filepath.Join(indexCacheDir(),
+		strings.NewReplacer(":", "_", "/", "_", ".", "_").Replace(uri)+"-"+arch+".idx")
+
+	cached, _ := readRepoIndex(cacheFile)
+	if cached != nil && cached.Format == indexFormat && cached.Source == source {
+		if cached.Size == info.Size() && cached.ModTime == info.ModTime().UnixNano() {
+			return cached, nil
 		}
 	}
-	return list
+
+	sum, err := fileSha256(source)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil && cached.Format == indexFormat && cached.Sha256 == sum {
+		cached.Size, cached.ModTime = info.Size(), info.ModTime().UnixNano()
+		writeCache(cacheFile, cached) // falhas apenas desativam o cache
+		return cached, nil
+	}
+
+	repo, err := repodata.Open(source, uri)
+	if err != nil {
+		return nil, err
+	}
+	ri := buildRepoIndex(repo)
+	ri.Source, ri.Sha256 = source, sum
+	ri.Size, ri.ModTime = info.Size(), info.ModTime().UnixNano()
+	writeCache(cacheFile, ri)
+	return ri, nil
 }
 
-func filterPackages(pkgs []Package, mode string) []Package {
-	var filtered []Package
-	for _, p := range pkgs {
-		isInstalled := strings.Contains(p.Status, "*")
-		if (mode == "installed" && isInstalled) || (mode == "missing" && !isInstalled) {
-			filtered = append(filtered, p)
+func buildRepoIndex(repo *repodata.Repodata) *repoIndex {
+	ri := &repoIndex{Format: indexFormat, URI: repo.URI, Tokens: make(map[string][]int32)}
+	names := make([]string, 0, len(repo.Packages))
+	for name := range repo.Packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		ri.Packages = append(ri.Packages, *repo.Packages[name])
+		seen := make(map[string]bool)
+		for _, tok := range tokenize(name + " " + repo.Packages[name].ShortDesc) {
+			if !seen[tok] {
+				seen[tok] = true
+				ri.Tokens[tok] = append(ri.Tokens[tok], int32(i))
+			}
 		}
 	}
-	return filtered
+	return ri
 }
 
-// --- FUNÇÕES DE SISTEMA, LIMPEZA E FIND ---
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
 
-func findProvides(file string, searchRemote bool) {
-	fmt.Printf("%s %s '%s'...\n", cyan("[vinstall]"), white("Procurando pacote que contém:"), yellow(file))
+func fileSha256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
 
-	fmt.Printf("%s %s %s\n", cyan(">>>"), cyan("grep local /var/db/xbps/.*-files.plist"), yellow(file))
-	fmt.Printf("%s %s %s %s\n", cyan(">>>"), cyan("xbps-query"), cyan("-o"), yellow(file))
+func readRepoIndex(path string) (*repoIndex, error) {
+	var ri repoIndex
+	if err := readCache(path, &ri); err != nil {
+		return nil, err
+	}
+	for i := range ri.Packages {
+		ri.Packages[i].Repo = ri.URI
+	}
+	return &ri, nil
+}
 
-	xPath, xlocateErr := exec.LookPath("xlocate")
-	if xlocateErr == nil {
-		checkXlocateIndex()
-		fmt.Printf("%s %s %s\n", cyan(">>>"), cyan("xlocate"), yellow(file))
+func readCache(path string, v interface{}) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
 	}
-	if searchRemote {
-		fmt.Printf("%s %s %s %s\n", cyan(">>>"), cyan("xbps-query"), cyan("-Ro"), yellow(file))
+	defer file.Close()
+	zr, err := zstd.NewReader(file)
+	if err != nil {
+		return err
 	}
+	defer zr.Close()
+	return gob.NewDecoder(zr).Decode(v)
+}
 
-	var wg sync.WaitGroup
-	var mu sync.Mutex
-	found := false
-	var lines []string
+// writeCache grava em arquivo temporário e renomeia, para que uma busca
+// concorrente nunca leia um índice pela metade.
+func writeCache(path string, v interface{}) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".idx-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	zw, err := zstd.NewWriter(tmp)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := gob.NewEncoder(zw).Encode(v); err != nil {
+		zw.Close()
+		tmp.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
 
-	emit := func(s string) {
-		mu.Lock()
-		defer mu.Unlock()
-		found = true
-		lines = append(lines, s)
+func (ix *PackageIndex) All() []*repodata.Package {
+	var pkgs []*repodata.Package
+	for _, ri := range ix.Repos {
+		for i := range ri.Packages {
+			pkgs = append(pkgs, &ri.Packages[i])
+		}
 	}
+	return pkgs
+}
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		if searchInLocalPlist(file) {
-			mu.Lock()
-			found = true
-			mu.Unlock()
+// Lookup escolhe o candidato como o xbps: o primeiro repositório que tem o
+// pacote, na ordem da configuração, ou a maior versão entre todos quando o
+// xbps.d define bestmatching=true.
+func (ix *PackageIndex) Lookup(name string) *repodata.Package {
+	var best *repodata.Package
+	for _, ri := range ix.Repos {
+		i := sort.Search(len(ri.Packages), func(i int) bool { return ri.Packages[i].Name >= name })
+		if i < len(ri.Packages) && ri.Packages[i].Name == name {
+			if !ix.BestMatching {
+				return &ri.Packages[i]
+			}
+			if best == nil || cmpVersion(ri.Packages[i].Pkgver, best.Pkgver) > 0 {
+				best = &ri.Packages[i]
+			}
 		}
-	}()
+	}
+	return best
+}
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		ctx, cancel := context.WithTimeout(context.Background(), execTimeout)
-		defer cancel()
-		outLoc, _ := exec.CommandContext(ctx, "xbps-query", "-o", file).Output()
-		if resLoc := strings.TrimSpace(string(outLoc)); resLoc != "" {
-			emit(green(resLoc + " (instalado)"))
+// Prefix busca pelo início do nome do pacote.
+func (ix *PackageIndex) Prefix(prefix string) []*repodata.Package {
+	var pkgs []*repodata.Package
+	for _, ri := range ix.Repos {
+		i := sort.Search(len(ri.Packages), func(i int) bool { return ri.Packages[i].Name >= prefix })
+		for ; i < len(ri.Packages) && strings.HasPrefix(ri.Packages[i].Name, prefix); i++ {
+			pkgs = append(pkgs, &ri.Packages[i])
 		}
-	}()
+	}
+	return pkgs
+}
 
-	if xlocateErr == nil {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			ctx, cancel := context.WithTimeout(context.Background(), execTimeout)
-			defer cancel()
-			output, _ := exec.CommandContext(ctx, xPath, file).Output()
-			res := strings.TrimSpace(string(output))
-			if res == "" {
-				return
-			}
-			seen := make(map[string]bool)
-			mu.Lock()
-			for _, line := range strings.Split(res, "\n") {
-				if line != "" && !seen[line] {
-					found = true
-					lines = append(lines, green(line))
-					seen[line] = true
-				}
+// Substring reproduz o xbps-query -Rs: pkgver ou short_desc contendo o termo.
+func (ix *PackageIndex) Substring(query string) []*repodata.Package {
+	query = strings.ToLower(query)
+	var pkgs []*repodata.Package
+	for _, ri := range ix.Repos {
+		for i := range ri.Packages {
+			pkg := &ri.Packages[i]
+			if strings.Contains(strings.ToLower(pkg.Pkgver), query) ||
+				strings.Contains(strings.ToLower(pkg.ShortDesc), query) {
+				pkgs = append(pkgs, pkg)
 			}
-			mu.Unlock()
-		}()
+		}
 	}
+	return pkgs
+}
 
-	if searchRemote {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			ctx, cancel := context.WithTimeout(context.Background(), execTimeout)
-			defer cancel()
-			output, _ := exec.CommandContext(ctx, "xbps-query", "-Ro", file).Output()
-			if res := strings.TrimSpace(string(output)); res != "" {
-				emit(green(res))
+// Tokens exige que todas as palavras da consulta apareçam no nome ou na
+// descrição do pacote.
+func (ix *PackageIndex) Tokens(query string) []*repodata.Package {
+	words := tokenize(query)
+	var pkgs []*repodata.Package
+	if len(words) == 0 {
+		return pkgs
+	}
+	for _, ri := range ix.Repos {
+		count := make(map[int32]int)
+		for _, w := range words {
+			for _, i := range ri.Tokens[w] {
+				count[i]++
 			}
-		}()
+		}
+		var hits []int
+		for i, n := range count {
+			if n == len(words) {
+				hits = append(hits, int(i))
+			}
+		}
+		sort.Ints(hits)
+		for _, i := range hits {
+			pkgs = append(pkgs, &ri.Packages[i])
+		}
 	}
+	return pkgs
+}
 
-	wg.Wait()
+// Search escolhe a consulta pelo formato do termo: "fire*" busca por prefixo,
+// várias palavras exigem todos os tokens e o resto é substring.
+func (ix *PackageIndex) Search(query string) []*repodata.Package {
+	switch {
+	case strings.HasSuffix(query, "*") && !strings.ContainsAny(query, " \t"):
+		return ix.Prefix(strings.ToLower(strings.TrimSuffix(query, "*")))
+	case len(strings.Fields(query)) > 1:
+		return ix.Tokens(query)
+	}
+	return ix.Substring(query)
+}
 
-	for _, l := range lines {
-		fmt.Println(l)
+func newSearchPackage(pkg *repodata.Package, installed map[string]bool) Package {
+	status := red("[-]")
+	if installed[pkg.Pkgver] {
+		status = green("[✔]")
 	}
-	if !found {
-		fmt.Printf("%s %s\n", red("[!]"), white("Nenhum pacote encontrado. Use -FR para busca profunda."))
+	return Package{
+		Status:        status,
+		Installed:     installed[pkg.Pkgver],
+		FullName:      pkg.Pkgver,
+		Description:   pkg.ShortDesc,
+		Maintainer:    pkg.Maintainer,
+		Repo:          pkg.Repo,
+		SizeDownload:  pkg.FilenameSize,
+		SizeInstalled: pkg.InstalledSize,
 	}
 }
 
-func listLocal(mode string, query string) {
-	var cmd *exec.Cmd
-	switch mode {
-	case "installed":
-		fmt.Printf("%s %s\n", cyan("[vinstall]"), white("Listando pacotes instalados:"))
-		cmd = exec.Command("xbps-query", "-l")
-	case "orphans":
-		fmt.Printf("%s %s\n", cyan("[vinstall]"), white("Listando pacotes órfãos:"))
-		cmd = exec.Command("xbps-query", "-O")
-	case "search":
-		fmt.Printf("%s %s '%s'...\n", cyan("[vinstall]"), white("Buscando localmente por:"), yellow(query))
-		cmd = exec.Command("xbps-query", "-l")
+// newSuggestion usa o status no formato do xbps-query -Rs ([*]/[-]), que é o
+// que displaySearch e filterPackages esperam.
+func newSuggestion(pkg *repodata.Package, installed map[string]bool) Package {
+	p := newSearchPackage(pkg, installed)
+	p.Status = "[-]"
+	if p.Installed {
+		p.Status = "[*]"
 	}
+	return p
+}
 
-	output, _ := cmd.Output()
-	lines := strings.Split(string(output), "\n")
+// --- CONSULTA ESTRUTURADA (-Sss) ---
 
-	w := bufio.NewWriter(os.Stdout)
-	defer w.Flush()
+// queryNode é um nó da árvore de uma consulta do -Sss, por exemplo:
+//
+//	name:firefox NOT name:esr
+//	maint:"Foo Bar" OR license:/^mit/
+//	(desc:browser OR desc:mail) size>50M installed:no
+//
+// Termos lado a lado equivalem a AND; '-' ou '!' antes de um termo negam.
+type queryNode interface {
+	match(pkg *repodata.Package, installed bool) bool
+}
 
-	count := 0
-	for _, line := range lines {
-		if line == "" {
-			continue
+type andNode []queryNode
+type orNode []queryNode
+type notNode struct{ node queryNode }
+
+type termNode struct {
+	field string
+	op    string
+	value string
+	re    *regexp.Regexp
+	size  int64
+	flag  bool
+}
+
+func (n andNode) match(pkg *repodata.Package, installed bool) bool {
+	for _, c := range n {
+		if !c.match(pkg, installed) {
+			return false
 		}
-		if mode == "search" && !strings.Contains(strings.ToLower(line), strings.ToLower(query)) {
-			continue
+	}
+	return true
+}
+
+func (n orNode) match(pkg *repodata.Package, installed bool) bool {
+	for _, c := range n {
+		if c.match(pkg, installed) {
+			return true
 		}
-		fmt.Fprintln(w, white(line))
-		count++
 	}
-	fmt.Fprintf(w, "\n%s %s: %s\n", yellow("[!]"), white("Total:"), cyan(strconv.Itoa(count)))
+	return false
 }
 
-func cleanXbpsCache() {
-	cachePath := "/var/cache/xbps"
-	if os.Geteuid() != 0 {
-		fmt.Printf("%s %s\n", yellow("[vinstall]"), white("A limpeza do cache requer privilégios de root."))
-		cmd := exec.Command("sudo", os.Args[0], "-Scc")
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		cmd.Stdin = os.Stdin
-		cmd.Run()
-		return
+func (n notNode) match(pkg *repodata.Package, installed bool) bool {
+	return !n.node.match(pkg, installed)
+}
+
+func (t *termNode) match(pkg *repodata.Package, installed bool) bool {
+	switch t.field {
+	case "":
+		// termo livre: mantém a busca original do -Sss, que junta pkgver,
+		// descrição, mantenedor e repositório e ignora espaços
+		if t.re != nil {
+			return t.text(pkg.Pkgver) || t.text(pkg.ShortDesc) || t.text(pkg.Maintainer) || t.text(pkg.Repo)
+		}
+		full := pkg.Pkgver + " " + pkg.ShortDesc + " " + pkg.Maintainer + " " + pkg.Repo
+		return strings.Contains(strings.ToLower(strings.ReplaceAll(full, " ", "")),
+			strings.ToLower(strings.ReplaceAll(t.value, " ", "")))
+	case "name":
+		return t.text(pkg.Name)
+	case "pkgver":
+		return t.text(pkg.Pkgver)
+	case "desc":
+		return t.text(pkg.ShortDesc)
+	case "maint":
+		return t.text(pkg.Maintainer)
+	case "repo":
+		return t.text(pkg.Repo)
+	case "license":
+		return t.text(pkg.License)
+	case "homepage":
+		return t.text(pkg.Homepage)
+	case "dep":
+		for _, dep := range pkg.RunDepends {
+			if t.text(patternName(dep)) {
+				return true
+			}
+		}
+		return false
+	case "installed":
+		return installed == t.flag
+	case "size":
+		return t.compare(pkg.InstalledSize)
+	case "dsize":
+		return t.compare(pkg.FilenameSize)
 	}
+	return false
+}
 
-	files, err := os.ReadDir(cachePath)
-	if err != nil {
-		return
+func (t *termNode) text(s string) bool {
+	if t.re != nil {
+		return t.re.MatchString(s)
 	}
+	return strings.Contains(strings.ToLower(s), strings.ToLower(t.value))
+}
 
-	var pkgCount int
-	var totalSize int64
-	fmt.Printf("%s %s\n", cyan("[vinstall]"), white("Iniciando limpeza do cache..."))
+func (t *termNode) compare(n int64) bool {
+	switch t.op {
+	case ">":
+		return n > t.size
+	case ">=":
+		return n >= t.size
+	case "<":
+		return n < t.size
+	case "<=":
+		return n <= t.size
+	}
+	return n == t.size
+}
 
-	for _, file := range files {
-		if file.IsDir() {
-			continue
+// queryFields mapeia os nomes aceitos na consulta (e seus apelidos) para o
+// campo avaliado em termNode.match.
+var queryFields = map[string]string{
+	"name":        "name",
+	"pkgname":     "name",
+	"pkgver":      "pkgver",
+	"version":     "pkgver",
+	"ver":         "pkgver",
+	"desc":        "desc",
+	"description": "desc",
+	"maint":       "maint",
+	"maintainer":  "maint",
+	"repo":        "repo",
+	"repository":  "repo",
+	"license":     "license",
+	"homepage":    "homepage",
+	"url":         "homepage",
+	"dep":         "dep",
+	"depends":     "dep",
+	"installed":   "installed",
+	"size":        "size",
+	"dsize":       "dsize",
+}
+
+type queryParser struct {
+	tokens []string
+	pos    int
+}
+
+func parseQuery(query string) (queryNode, error) {
+	tokens, err := lexQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("consulta vazia")
+	}
+	p := &queryParser{tokens: tokens}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("token inesperado %q", p.tokens[p.pos])
+	}
+	return node, nil
+}
+
+// lexQuery separa a consulta em tokens, mantendo "frases entre aspas" e
+// /regex/ inteiros, inclusive quando vêm após um campo (desc:"web browser").
+func lexQuery(query string) ([]string, error) {
+	var tokens []string
+	var cur strings.Builder
+	flush := func() {
+		if cur.Len() > 0 {
+			tokens = append(tokens, cur.String())
+			cur.Reset()
 		}
-		name := file.Name()
-		if strings.HasSuffix(name, ".xbps") || strings.HasSuffix(name, ".sig2") {
-			info, err := file.Info()
-			if err == nil {
-				totalSize += info.Size()
-				if os.Remove(filepath.Join(cachePath, name)) == nil && strings.HasSuffix(name, ".xbps") {
-					pkgCount++
+	}
+	runes := []rune(query)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			flush()
+		case (r == '(' || r == ')') && cur.Len() == 0:
+			tokens = append(tokens, string(r))
+		case r == ')':
+			flush()
+			tokens = append(tokens, ")")
+		case r == '"' || (r == '/' && startsValue(cur.String())):
+			end := i + 1
+			for end < len(runes) && runes[end] != r {
+				if runes[end] == '\\' && r == '/' {
+					end++
 				}
+				end++
+			}
+			// Um termo solto como /usr/bin/foo é texto, não regex: sem campo,
+			// só vale /regex/ fechada antes de espaço ou ')'.
+			bare := r == '/' && !strings.HasSuffix(cur.String(), ":")
+			if bare && (end >= len(runes) || (end+1 < len(runes) && !unicode.IsSpace(runes[end+1]) && runes[end+1] != ')')) {
+				cur.WriteRune(r)
+				continue
+			}
+			if end >= len(runes) {
+				return nil, fmt.Errorf("%c sem fechamento", r)
 			}
+			cur.WriteString(string(runes[i : end+1]))
+			i = end
+		default:
+			cur.WriteRune(r)
 		}
 	}
+	flush()
+	return tokens, nil
+}
 
-	fmt.Printf("\n%s %s\n", green("[✔]"), white("Limpeza concluída!"))
-	fmt.Printf("%s %s %s\n", yellow("[!]"), white("Removidos:"), cyan(strconv.Itoa(pkgCount)))
-	fmt.Printf("%s %s %s\n", yellow("[!]"), white("Espaço livre:"), green(formatBytes(totalSize)))
+// queryOptions são as opções do vinstall com valor que podem aparecer depois
+// da consulta do -Sss.
+var queryOptions = map[string]bool{"--output": true, "--arch": true}
 
-	cmd := exec.Command("xbps-query", "-O")
-	out, _ := cmd.Output()
-	if orphans := strings.TrimSpace(string(out)); orphans != "" {
-		fmt.Printf("%s %s\n%s\n", yellow("[!]"), white("Órfãos encontrados:"), cyan(orphans))
-		fmt.Printf("%s ", white("Remover órfãos? [s/N]: "))
-		reader := bufio.NewReader(os.Stdin)
-		ans, _ := reader.ReadString('\n')
-		if a := strings.ToLower(strings.TrimSpace(ans)); a == "s" || a == "sim" {
-			runBinary("xbps-remove", []string{"-o"}, []string{})
+// splitQueryArgs separa o que vem depois de -Sss: termos da consulta,
+// inclusive negações como -devel ou !esr, e opções longas do vinstall
+// (--output json), que voltam para o parser de argumentos. Depois de "--"
+// tudo é termo.
+func splitQueryArgs(args []string) (terms, opts []string) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			return append(terms, args[i+1:]...), opts
+		case strings.HasPrefix(arg, "--"):
+			opts = append(opts, arg)
+			if queryOptions[arg] && i+1 < len(args) {
+				i++
+				opts = append(opts, args[i])
+			}
+		default:
+			terms = append(terms, arg)
 		}
 	}
+	return terms, opts
 }
 
-func checkAndEnableService(pkgName string) {
-	servicePath := filepath.Join("/etc/sv", pkgName)
-	targetPath := filepath.Join("/var/service", pkgName)
-	if info, err := os.Stat(servicePath); err == nil && info.IsDir() {
-		if _, err := os.Lstat(targetPath); os.IsNotExist(err) {
-			fmt.Printf("\n%s %s '%s'. Ativar? [s/N]: ", yellow("[!]"), white("Serviço disponível para"), cyan(pkgName))
-			reader := bufio.NewReader(os.Stdin)
-			input, _ := reader.ReadString('\n')
-			if a := strings.ToLower(strings.TrimSpace(input)); a == "s" || a == "sim" {
-				exec.Command("sudo", "ln", "-s", servicePath, targetPath).Run()
-			}
-		}
+// startsValue indica se o próximo caractere inicia o valor de um termo, isto
+// é, se o token atual está vazio ou termina em ':' (name:/regex/).
+func startsValue(cur string) bool {
+	return cur == "" || cur == "-" || cur == "!" || strings.HasSuffix(cur, ":")
+}
+
+func (p *queryParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
 	}
+	return ""
 }
 
-func showHistory() {
-	logPath := "/var/log/socklog/xbps/current"
-	file, err := os.Open(logPath)
+func (p *queryParser) parseOr() (queryNode, error) {
+	node, err := p.parseAnd()
 	if err != nil {
-		if os.IsPermission(err) {
-			runBinary(os.Args[0], []string{"--history"}, []string{})
-			return
-		}
-		return
+		return nil, err
 	}
-	defer file.Close()
-	fmt.Printf("\n%s %s\n", cyan("[vinstall]"), white("Histórico:"))
-	width := getTerminalWidth()
-	fmt.Println(white(strings.Repeat("─", width)))
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if len(line) > 25 {
-			line = line[25:]
-		}
-		if strings.Contains(line, "installed") {
-			fmt.Println(green(line))
-		} else if strings.Contains(line, "removed") {
-			fmt.Println(red(line))
-		} else {
-			fmt.Println(white(line))
+	nodes := orNode{node}
+	for tok := p.peek(); tok == "OR" || tok == "||" || tok == "|"; tok = p.peek() {
+		p.pos++
+		next, err := p.parseAnd()
+		if err != nil {
+			return nil, err
 		}
+		nodes = append(nodes, next)
 	}
-	fmt.Println(white(strings.Repeat("─", width)))
+	if len(nodes) == 1 {
+		return node, nil
+	}
+	return nodes, nil
 }
 
-func searchInLocalPlist(file string) bool {
-	dbPath := "/var/db/xbps"
-	entries, err := os.ReadDir(dbPath)
-	if err != nil {
-		return false
-	}
-	foundLocal := false
-	for _, e := range entries {
-		if strings.HasSuffix(e.Name(), "-files.plist") {
-			content, err := os.ReadFile(filepath.Join(dbPath, e.Name()))
-			if err != nil {
-				continue
-			}
-			data := string(content)
-			if strings.Contains(data, file) {
-				lines := strings.Split(data, "\n")
-				for _, line := range lines {
-					if strings.Contains(line, "<string>") && strings.Contains(line, file) {
-						pkgName := e.Name()
-						pkgName = strings.TrimPrefix(pkgName, ".")
-						pkgName = strings.TrimSuffix(pkgName, "-files.plist")
-						fmt.Println(green(pkgName + " (instalado localmente via plist)"))
-						foundLocal = true
-						break
-					}
-				}
-			}
+func (p *queryParser) parseAnd() (queryNode, error) {
+	var nodes andNode
+	for {
+		tok := p.peek()
+		if tok == "" || tok == ")" || tok == "OR" || tok == "||" || tok == "|" {
+			break
+		}
+		if tok == "AND" || tok == "&&" || tok == "&" {
+			p.pos++
+			continue
+		}
+		node, err := p.parseNot()
+		if err != nil {
+			return nil, err
 		}
+		nodes = append(nodes, node)
 	}
-	return foundLocal
+	switch len(nodes) {
+	case 0:
+		return nil, fmt.Errorf("expressão incompleta")
+	case 1:
+		return nodes[0], nil
+	}
+	return nodes, nil
+}
+
+func (p *queryParser) parseNot() (queryNode, error) {
+	tok := p.peek()
+	switch {
+	case tok == "NOT" || tok == "!":
+		p.pos++
+		node, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{node}, nil
+	case len(tok) > 1 && (tok[0] == '-' || tok[0] == '!'):
+		p.tokens[p.pos] = tok[1:]
+		node, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{node}, nil
+	case tok == "(":
+		p.pos++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("')' esperado")
+		}
+		p.pos++
+		return node, nil
+	}
+	p.pos++
+	return parseTerm(tok)
+}
+
+func parseTerm(tok string) (queryNode, error) {
+	t := &termNode{value: tok}
+	if i := strings.IndexAny(tok, ":<>="); i > 0 {
+		if field, ok := queryFields[strings.ToLower(tok[:i])]; ok {
+			t.field = field
+			rest := tok[i:]
+			switch {
+			case strings.HasPrefix(rest, ">="), strings.HasPrefix(rest, "<="):
+				t.op, t.value = rest[:2], rest[2:]
+			default:
+				t.op, t.value = rest[:1], rest[1:]
+			}
+		}
+	}
+
+	switch t.field {
+	case "size", "dsize":
+		if t.op == ":" {
+			t.op = "="
+		}
+		size, err := parseSize(t.value)
+		if err != nil {
+			return nil, err
+		}
+		t.size = size
+		return t, nil
+	case "installed":
+		switch strings.ToLower(t.value) {
+		case "yes", "sim", "y", "s", "true", "1":
+			t.flag = true
+		case "no", "nao", "não", "n", "false", "0":
+			t.flag = false
+		default:
+			return nil, fmt.Errorf("installed: espera yes ou no, não %q", t.value)
+		}
+		return t, nil
+	}
+	if t.op != "" && t.op != ":" {
+		return nil, fmt.Errorf("operador %q só vale para size e dsize", t.op)
+	}
+
+	switch v := t.value; {
+	case len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"':
+		t.value = v[1 : len(v)-1]
+	case len(v) >= 2 && v[0] == '/' && v[len(v)-1] == '/':
+		re, err := regexp.Compile("(?i)" + v[1:len(v)-1])
+		if err != nil {
+			return nil, fmt.Errorf("regex inválida %s: %w", v, err)
+		}
+		t.re = re
+	}
+	if t.value == "" && t.re == nil {
+		return nil, fmt.Errorf("valor vazio em %q", tok)
+	}
+	return t, nil
+}
+
+// parseSize aceita bytes puros ou sufixos K, M, G e T (base 1024, como em
+// formatBytes), com "B"/"iB" opcionais: 512K, 1.5G, 50MiB.
+func parseSize(s string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	value = strings.TrimSuffix(strings.TrimSuffix(value, "B"), "I")
+	mult := 1.0
+	if value != "" {
+		if i := strings.IndexByte("KMGT", value[len(value)-1]); i >= 0 {
+			mult = math.Pow(1024, float64(i+1))
+			value = value[:len(value)-1]
+		}
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("tamanho inválido %q", s)
+	}
+	return int64(n * mult), nil
+}
+
+// --- SUGESTÕES (BUSCA APROXIMADA) ---
+
+const maxSuggestions = 15
+
+type scoredPackage struct {
+	pkg   *repodata.Package
+	score float64
+}
+
+// suggestPackages alimenta o menu de "você quis dizer" quando o xbps-install
+// falha. Sem repodata local, cai na busca por substring do xbps-query.
+func suggestPackages(query string) []Package {
+	index := loadPackageIndex(repoArch())
+	if len(index.Repos) == 0 {
+		return fetchSuggestions(query)
+	}
+	installed := getInstalledPackages()
+	var pkgs []Package
+	for _, sp := range rankPackages(query, index, installed, maxSuggestions) {
+		pkgs = append(pkgs, newSuggestion(sp.pkg, installed))
+	}
+	return pkgs
+}
+
+// rankPackages pontua cada pacote do índice contra a consulta. Só entram
+// pacotes com algum vínculo real (nome exato, prefixo, substring, distância
+// de edição tolerável ou palavra da descrição); instalação e popularidade
+// (quantos pacotes dependem dele) apenas desempatam.
+func rankPackages(query string, index *PackageIndex, installed map[string]bool, limit int) []scoredPackage {
+	q := strings.ToLower(strings.TrimSpace(query))
+	if q == "" {
+		return nil
+	}
+	qTokens := tokenize(q)
+	maxDist := 1
+	if n := len([]rune(q)); n > 8 {
+		maxDist = 3
+	} else if n > 4 {
+		maxDist = 2
+	}
+
+	popularity := make(map[string]int)
+	all := index.All()
+	for _, pkg := range all {
+		for _, dep := range pkg.RunDepends {
+			popularity[patternName(dep)]++
+		}
+	}
+
+	seen := make(map[string]bool)
+	var ranked []scoredPackage
+	for _, pkg := range all {
+		if seen[pkg.Name] {
+			continue
+		}
+		seen[pkg.Name] = true
+		pkg = index.Lookup(pkg.Name)
+		name := strings.ToLower(pkg.Name)
+
+		score := 0.0
+		switch {
+		case name == q:
+			score = 100
+		case strings.HasPrefix(name, q):
+			score = 60 - float64(len(name)-len(q))/2
+		case strings.Contains(name, q):
+			score = 40
+		}
+		if d := nameDistance(q, name); d <= maxDist && score < 50-15*float64(d) {
+			score = 50 - 15*float64(d)
+		}
+		descTokens := tokenize(pkg.ShortDesc)
+		for _, qt := range qTokens {
+			for _, dt := range descTokens {
+				if dt == qt {
+					score += 10
+					break
+				} else if len(qt) > 2 && strings.HasPrefix(dt, qt) {
+					score += 5
+					break
+				}
+			}
+		}
+		if score <= 0 {
+			continue
+		}
+		if installed[pkg.Pkgver] {
+			score += 5
+		}
+		score += math.Min(10, 2*math.Log2(1+float64(popularity[pkg.Name])))
+		ranked = append(ranked, scoredPackage{pkg: pkg, score: score})
+	}
+
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].score != ranked[j].score {
+			return ranked[i].score > ranked[j].score
+		}
+		return ranked[i].pkg.Name < ranked[j].pkg.Name
+	})
+	if limit > 0 && len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+	return ranked
+}
+
+// nameDistance compara a consulta com o nome inteiro, com o início do nome
+// (para "telegran" achar "telegram-desktop") e com cada parte separada por
+// '-', ficando com a menor distância.
+func nameDistance(q, name string) int {
+	best := damerauLevenshtein(q, name)
+	if r := []rune(name); len(r) > len([]rune(q)) {
+		if d := damerauLevenshtein(q, string(r[:len([]rune(q))])) + 1; d < best {
+			best = d
+		}
+	}
+	for _, part := range strings.Split(name, "-") {
+		if d := damerauLevenshtein(q, part) + 1; part != name && d < best {
+			best = d
+		}
+	}
+	return best
+}
+
+// damerauLevenshtein calcula a distância de edição com transposição de
+// caracteres adjacentes (optimal string alignment).
+func damerauLevenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
+
+// --- FUNÇÕES DE BUSCA RÁPIDA E OUTROS ---
+
+func fetchSuggestions(query string) []Package {
+	index := loadPackageIndex(repoArch())
+	if len(index.Repos) > 0 {
+		installed := getInstalledPackages()
+		var pkgs []Package
+		for _, pkg := range index.Search(query) {
+			pkgs = append(pkgs, newSuggestion(pkg, installed))
+		}
+		return pkgs
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), execTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "xbps-query", "-Rs", query)
+	out, _ := cmd.Output()
+	var pkgs []Package
+	for _, line := range strings.Split(string(out), "\n") {
+		if len(line) < 4 {
+			continue
+		}
+		status := line[0:4]
+		rest := strings.TrimSpace(line[4:])
+		name, desc, _ := strings.Cut(rest, " ")
+		if name != "" {
+			pkgs = append(pkgs, Package{Status: strings.TrimSpace(status), Installed: strings.Contains(status, "*"),
+				FullName: name, Description: strings.TrimSpace(desc)})
+		}
+	}
+	return pkgs
+}
+
+func displaySearch(pkgs []Package, title string) {
+	if len(pkgs) == 0 {
+		return
+	}
+	width := getTerminalWidth()
+	lineSeparator := white(strings.Repeat("─", width))
+	maxNameLen := 0
+	for _, p := range pkgs {
+		if len(p.FullName) > maxNameLen {
+			maxNameLen = len(p.FullName)
+		}
+	}
+	if maxNameLen > 50 {
+		maxNameLen = 50
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+
+	if title != "" {
+		fmt.Fprintf(w, "\n%s\n", cyan(title))
+	}
+	fmt.Fprintf(w, "%s\n", lineSeparator)
+
+	for i, p := range pkgs {
+		idx := yellow(fmt.Sprintf("[%2d]", i+1))
+		statusDisplay := "[-] "
+		statusColor := red(statusDisplay)
+		if p.Installed {
+			statusDisplay = "[✓] "
+			statusColor = green_bold(statusDisplay)
+		}
+		fmt.Fprintf(w, "%s %s %s  %s\n", idx, statusColor, white(fmt.Sprintf("%-*s", maxNameLen, p.FullName)), green(p.Description))
+	}
+	fmt.Fprintln(w, lineSeparator)
+}
+
+func displayMenu(pkgs []Package, flags []string) bool {
+	if len(pkgs) == 0 {
+		return false
+	}
+	displaySearch(pkgs, "\nSugestões encontradas no repositório:")
+	fmt.Printf("%s", yellow("Selecione (ex: 1 3 5-7, 'a' para todos, ^4 exclui) ou 'q' para sair: "))
+	reader := bufio.NewReader(os.Stdin)
+	input, _ := reader.ReadString('\n')
+	input = strings.TrimSpace(input)
+	if input == "q" || input == "" {
+		return false
+	}
+	choices, err := parseSelection(input, len(pkgs))
+	if err != nil {
+		fmt.Printf("%s %v\n", red("[!]"), err)
+		return false
+	}
+	if len(choices) == 0 {
+		return false
+	}
+
+	var index *PackageIndex
+	var names []string
+	var totalDown, totalInst int64
+	fmt.Printf("\n%s\n", cyan("Pacotes selecionados:"))
+	for _, i := range choices {
+		p := pkgs[i]
+		if p.SizeDownload == 0 && p.SizeInstalled == 0 {
+			if index == nil {
+				index = loadPackageIndex(repoArch())
+			}
+			if rp := index.Lookup(patternName(p.FullName)); rp != nil {
+				p.SizeDownload, p.SizeInstalled = rp.FilenameSize, rp.InstalledSize
+			}
+		}
+		totalDown += p.SizeDownload
+		totalInst += p.SizeInstalled
+		names = append(names, patternName(p.FullName))
+		fmt.Printf("  %s %s (%s / %s)\n", yellow(fmt.Sprintf("[%d]", i+1)), white(p.FullName),
+			yellow(formatBytes(p.SizeDownload)), magenta(formatBytes(p.SizeInstalled)))
+	}
+	fmt.Printf("%s %s %s %s %s\n", yellow("[!]"), white("Download:"), yellow(formatBytes(totalDown)),
+		white("Instalado:"), magenta(formatBytes(totalInst)))
+	fmt.Printf("%s ", white(fmt.Sprintf("Instalar %d pacote(s)? [S/n]: ", len(names))))
+	ans, _ := reader.ReadString('\n')
+	if a := strings.ToLower(strings.TrimSpace(ans)); a != "" && a != "s" && a != "sim" {
+		return false
+	}
+
+	if !runBinary("xbps-install", flags, names) {
+		return false
+	}
+	for _, name := range names {
+		checkAndEnableService(name)
+	}
+	return true
+}
+
+// parseSelection interpreta a seleção do menu (índices de 1 a n): números,
+// faixas "5-7", "a" para todos e "^4" ou "^2-3" para excluir. Se só houver
+// exclusões, parte de todos os itens. Devolve índices base 0, ordenados.
+func parseSelection(input string, n int) ([]int, error) {
+	selected := make(map[int]bool)
+	excluded := make(map[int]bool)
+	onlyExclusions := true
+
+	parseRange := func(tok string) (int, int, error) {
+		lo, hi, isRange := strings.Cut(tok, "-")
+		start, err := strconv.Atoi(lo)
+		if err != nil {
+			return 0, 0, fmt.Errorf("seleção inválida: %q", tok)
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(hi); err != nil {
+				return 0, 0, fmt.Errorf("seleção inválida: %q", tok)
+			}
+		}
+		if start > end {
+			start, end = end, start
+		}
+		if start < 1 || end > n {
+			return 0, 0, fmt.Errorf("fora do intervalo 1-%d: %q", n, tok)
+		}
+		return start, end, nil
+	}
+
+	for _, tok := range strings.FieldsFunc(input, func(r rune) bool { return r == ' ' || r == ',' }) {
+		switch {
+		case tok == "a" || tok == "all" || tok == "*":
+			onlyExclusions = false
+			for i := 0; i < n; i++ {
+				selected[i] = true
+			}
+		case strings.HasPrefix(tok, "^"):
+			start, end, err := parseRange(tok[1:])
+			if err != nil {
+				return nil, err
+			}
+			for i := start; i <= end; i++ {
+				excluded[i-1] = true
+			}
+		default:
+			onlyExclusions = false
+			start, end, err := parseRange(tok)
+			if err != nil {
+				return nil, err
+			}
+			for i := start; i <= end; i++ {
+				selected[i-1] = true
+			}
+		}
+	}
+	if onlyExclusions {
+		for i := 0; i < n; i++ {
+			selected[i] = true
+		}
+	}
+
+	var choices []int
+	for i := 0; i < n; i++ {
+		if selected[i] && !excluded[i] {
+			choices = append(choices, i)
+		}
+	}
+	return choices, nil
+}
+
+func uniquePackagesExact(pkgs []Package) []Package {
+	keys := make(map[string]bool)
+	var list []Package
+	for _, p := range pkgs {
+		if !keys[p.FullName] {
+			keys[p.FullName] = true
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+func filterPackages(pkgs []Package, mode string) []Package {
+	var filtered []Package
+	for _, p := range pkgs {
+		if (mode == "installed" && p.Installed) || (mode == "missing" && !p.Installed) {
+			filtered = append(filtered, p)
+		}
+	}
+	return filtered
+}
+
+// --- PRÉVIA DA TRANSAÇÃO (--preview) ---
+
+// txAction é um pacote que entraria na transação do xbps-install.
+type txAction struct {
+	Action       string   `json:"action"`
+	Name         string   `json:"name"`
+	Pkgver       string   `json:"pkgver"`
+	OldPkgver    string   `json:"old_pkgver"`
+	RequiredBy   string   `json:"required_by"`
+	Repo         string   `json:"repo"`
+	SizeDownload int64    `json:"download_size"`
+	SizeDelta    int64    `json:"installed_size_delta"`
+	ConfFiles    []string `json:"conf_files"`
+}
+
+// txPreview é o resultado da resolução: ações em ordem de descoberta
+// (alvos primeiro, depois dependências) e o que não pôde ser resolvido.
+type txPreview struct {
+	Actions  []*txAction
+	Missing  []string
+	Download int64
+	Delta    int64
+}
+
+// resolveTransaction reproduz o que o xbps-install faria com os alvos,
+// seguindo run_depends pelo repodata. Dependências instaladas que atendem à
+// versão pedida (ou providas via provides) são consideradas satisfeitas; as
+// outras entram como atualização.
+func resolveTransaction(targets []string, index *PackageIndex, pkgdb map[string]*PkgdbEntry, update, force bool) *txPreview {
+	tx := &txPreview{}
+	providers := make(map[string]string)
+	for _, pkg := range index.All() {
+		for _, v := range pkg.Provides {
+			if _, ok := providers[patternName(v)]; !ok {
+				providers[patternName(v)] = pkg.Name
+			}
+		}
+	}
+	installedVirtual := make(map[string]bool)
+	for _, entry := range pkgdb {
+		for _, v := range entry.Provides {
+			installedVirtual[patternName(v)] = true
+		}
+	}
+
+	type pending struct {
+		name, parent string
+		pattern      string // restrição da dependência, ex: "glibc>=2.36_1"
+		target       bool
+	}
+	var queue []pending
+	if update {
+		held := make(map[string]bool)
+		for _, r := range holdRecords(index, pkgdb, true) {
+			held[r.Name] = true
+		}
+		for _, name := range sortedKeys(pkgdb) {
+			if !held[name] {
+				queue = append(queue, pending{name: name, target: true})
+			}
+		}
+	}
+	for _, t := range targets {
+		queue = append(queue, pending{name: patternName(t), target: true})
+	}
+
+	seen := make(map[string]bool)
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		if seen[cur.name] {
+			continue
+		}
+		seen[cur.name] = true
+
+		local := pkgdb[cur.name]
+		if !cur.target && installedVirtual[cur.name] {
+			continue
+		}
+		if !cur.target && local != nil && (cur.pattern == "" || pkgpatternMatch(local.Pkgver, cur.pattern)) {
+			continue
+		}
+		pkg := index.Lookup(cur.name)
+		if pkg == nil && !cur.target {
+			if provider, ok := providers[cur.name]; ok {
+				queue = append(queue, pending{name: provider, parent: cur.parent})
+				continue
+			}
+		}
+		if pkg == nil {
+			if local == nil {
+				tx.Missing = append(tx.Missing, cur.name)
+			}
+			continue
+		}
+
+		act := &txAction{Action: "install", Name: pkg.Name, Pkgver: pkg.Pkgver, RequiredBy: cur.parent,
+			Repo: pkg.Repo, SizeDownload: pkg.FilenameSize, SizeDelta: pkg.InstalledSize, ConfFiles: pkg.ConfFiles}
+		if local != nil {
+			switch {
+			case cmpVersion(pkg.Pkgver, local.Pkgver) > 0:
+				act.Action = "update"
+			case force:
+				act.Action = "reinstall"
+			default:
+				continue
+			}
+			act.OldPkgver = local.Pkgver
+			act.SizeDelta = pkg.InstalledSize - local.InstalledSize
+			if files, err := readPkgFiles(pkg.Name); err == nil {
+				for _, group := range [][]PkgFile{files.ConfFiles, files.Files} {
+					for _, f := range group {
+						if strings.HasPrefix(f.File, "/etc/") {
+							act.ConfFiles = append(act.ConfFiles, f.File)
+						}
+					}
+				}
+			}
+		}
+		act.ConfFiles = uniqueStrings(act.ConfFiles)
+		tx.Actions = append(tx.Actions, act)
+		tx.Download += act.SizeDownload
+		tx.Delta += act.SizeDelta
+
+		for _, dep := range pkg.RunDepends {
+			queue = append(queue, pending{name: patternName(dep), parent: pkg.Name, pattern: dep})
+		}
+	}
+	return tx
+}
+
+func uniqueStrings(list []string) []string {
+	seen := make(map[string]bool)
+	out := []string{}
+	for _, s := range list {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// dependencyChain monta "cairo ← gtk+3 ← firefox" a partir de RequiredBy.
+func (tx *txPreview) dependencyChain(act *txAction) string {
+	byName := make(map[string]*txAction)
+	for _, a := range tx.Actions {
+		byName[a.Name] = a
+	}
+	chain := []string{act.Name}
+	for cur := act; cur.RequiredBy != "" && len(chain) <= len(tx.Actions); {
+		chain = append(chain, cur.RequiredBy)
+		next, ok := byName[cur.RequiredBy]
+		if !ok {
+			break
+		}
+		cur = next
+	}
+	return strings.Join(chain, " ← ")
+}
+
+// showTransactionPreview calcula e mostra a transação sem pedir privilégios.
+// Com verbose=false (antes do xbps-install real) só aparece quando todos os
+// alvos foram resolvidos, deixando erros para o xbps e para as sugestões.
+func showTransactionPreview(targets, flags []string, verbose bool) {
+	index := loadPackageIndex(repoArch())
+	if len(index.Repos) == 0 {
+		if verbose {
+			fmt.Printf("%s %s %s\n", red("[!]"), white("Nenhum repodata encontrado para"), yellow(repoArch()))
+		}
+		return
+	}
+	pkgdb, _ := readPkgdb()
+	tx := resolveTransaction(targets, index, pkgdb, hasShortFlag(flags, 'u'), hasShortFlag(flags, 'f'))
+	if !verbose && (len(tx.Missing) > 0 || len(tx.Actions) == 0) {
+		return
+	}
+	if writeRecords(tx.Actions) {
+		return
+	}
+
+	width := getTerminalWidth()
+	fmt.Printf("\n%s %s\n", cyan("[vinstall]"), white("Prévia da transação:"))
+	fmt.Println(white(strings.Repeat("─", width)))
+	var etc []string
+	for _, act := range tx.Actions {
+		var label string
+		switch act.Action {
+		case "install":
+			label = green("[novo]     ")
+		case "update":
+			label = yellow("[atualizar]")
+		default:
+			label = magenta("[reinstal.]")
+		}
+		version := white(act.Pkgver)
+		if act.OldPkgver != "" && act.OldPkgver != act.Pkgver {
+			version = white(act.OldPkgver) + " → " + green(act.Pkgver)
+		}
+		fmt.Printf("%s %s  %s %s\n", label, version, yellow(formatBytes(act.SizeDownload)), magenta(formatSignedBytes(act.SizeDelta)))
+		if act.RequiredBy != "" {
+			fmt.Printf("%13s%s %s\n", "", cyan("via"), tx.dependencyChain(act))
+		}
+		for _, f := range act.ConfFiles {
+			etc = append(etc, f+" ("+act.Name+")")
+		}
+	}
+	for _, name := range tx.Missing {
+		fmt.Printf("%s %s %s\n", red("[ausente]  "), white(name), red("não encontrado nos repositórios"))
+	}
+	fmt.Println(white(strings.Repeat("─", width)))
+	if len(etc) > 0 {
+		fmt.Printf("%s %s\n", yellow("[!]"), white("Arquivos em /etc que serão tocados:"))
+		for _, f := range etc {
+			fmt.Printf("    %s\n", cyan(f))
+		}
+	}
+	fmt.Printf("%s %s %s   %s %s   %s %s\n", yellow("[!]"), white("Pacotes:"), cyan(strconv.Itoa(len(tx.Actions))),
+		white("Download:"), yellow(formatBytes(tx.Download)), white("Espaço em disco:"), magenta(formatSignedBytes(tx.Delta)))
+}
+
+// --- CONFLITOS DE ARQUIVOS (--check-conflicts) ---
+
+const xbpsCacheDir = "/var/cache/xbps"
+
+// conflictRecord é um arquivo do candidato que já pertence a outro pacote.
+type conflictRecord struct {
+	Package string `json:"package"`
+	Path    string `json:"path"`
+	Owner   string `json:"owner"`
+}
+
+func checkConflicts(targets []string) {
+	plain := outputFormat == "plain"
+	index := loadPackageIndex(repoArch())
+	owners := installedOwners()
+
+	var conflicts []conflictRecord
+	checked := 0
+	for _, target := range targets {
+		name := patternName(target)
+		pkg := index.Lookup(name)
+		if pkg == nil {
+			fmt.Fprintf(os.Stderr, "%s %s %s\n", red("[!]"), white(name), red("não encontrado nos repositórios"))
+			continue
+		}
+		paths, source, err := candidateFiles(pkg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s %s: %v\n", red("[!]"), pkg.Pkgver, err)
+			continue
+		}
+		checked++
+		if plain {
+			fmt.Printf("%s %s %s (%d arquivos, %s)\n", cyan("[vinstall]"), white("Verificando"), yellow(pkg.Pkgver), len(paths), source)
+		}
+		for _, path := range paths {
+			for _, owner := range owners.owners[path] {
+				if owner.Type == "dir" || patternName(owner.Pkgver) == pkg.Name {
+					continue
+				}
+				conflicts = append(conflicts, conflictRecord{Package: pkg.Pkgver, Path: path, Owner: owner.Pkgver})
+			}
+		}
+	}
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].Path < conflicts[j].Path })
+
+	if writeRecords(conflicts) || checked == 0 {
+		return
+	}
+	if len(conflicts) == 0 {
+		fmt.Printf("%s %s\n", green("[✔]"), white("Nenhum conflito de arquivos."))
+		return
+	}
+	width := getTerminalWidth()
+	fmt.Println(white(strings.Repeat("─", width)))
+	for _, c := range conflicts {
+		fmt.Printf("%s %s  %s %s\n", red("[conflito]"), white(c.Path), cyan("pertence a"), yellow(c.Owner))
+	}
+	fmt.Println(white(strings.Repeat("─", width)))
+	fmt.Printf("%s %s %s\n", yellow("[!]"), white("Conflitos:"), red(strconv.Itoa(len(conflicts))))
+}
+
+// candidateFiles obtém a lista de arquivos do pacote sem instalá-lo: do
+// arquivo .xbps no cache (ou no repositório local) e, se não houver, do
+// xbps-query -Rf, que baixa só o files.plist.
+func candidateFiles(pkg *repodata.Package) ([]string, string, error) {
+	arch := pkg.Architecture
+	if arch == "" {
+		arch = repoArch()
+	}
+	archive := pkg.Pkgver + "." + arch + ".xbps"
+	dirs := []string{xbpsCacheDir}
+	if strings.HasPrefix(pkg.Repo, "/") {
+		dirs = append(dirs, pkg.Repo)
+	}
+	for _, dir := range dirs {
+		path := filepath.Join(dir, archive)
+		if files, err := readArchiveFiles(path); err == nil {
+			var paths []string
+			for _, group := range [][]PkgFile{files.Files, files.ConfFiles, files.Links} {
+				for _, f := range group {
+					paths = append(paths, f.File)
+				}
+			}
+			return paths, path, nil
+		}
+	}
+	if fi, err := loadFileIndex(arch); err == nil {
+		if paths := fi.filesOf(pkg.Pkgver); len(paths) > 0 {
+			return paths, fileIndexPath(arch), nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), execTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "xbps-query", "-R", "-f", pkg.Pkgver).Output()
+	if err != nil {
+		return nil, "", fmt.Errorf("lista de arquivos indisponível: %w", err)
+	}
+	var paths []string
+	for _, line := range strings.Split(string(out), "\n") {
+		path, _, _ := strings.Cut(strings.TrimSpace(line), " -> ")
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths, "xbps-query -Rf", nil
+}
+
+// readArchiveFiles lê o files.plist de dentro de um pacote .xbps.
+func readArchiveFiles(path string) (*PkgFiles, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader, err := repodata.Decompress(file)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	tr := tar.NewReader(reader)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s: files.plist ausente", path)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimPrefix(hdr.Name, "./") != "files.plist" {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		var files PkgFiles
+		if _, err := plist.Unmarshal(data, &files); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return &files, nil
+	}
+}
+
+// --- DONOS DE ARQUIVOS (-F) ---
+
+// fileOwner identifica um pacote instalado dono de um caminho.
+type fileOwner struct {
+	Pkgver string
+	Type   string
+	Target string
+	Sha256 string
+}
+
+// ownerIndex é o índice reverso caminho → pacotes, montado a partir dos
+// files.plist de todos os pacotes instalados. Diretórios costumam ter vários
+// donos, e arquivos também, se instalados com --ignore-file-conflicts.
+type ownerIndex struct {
+	owners map[string][]fileOwner
+	byBase map[string][]string
+}
+
+var (
+	ownersOnce sync.Once
+	owners     *ownerIndex
+)
+
+// installedOwners monta o índice uma única vez por execução; buscas
+// seguintes (vários alvos no -F, --check-conflicts) são consultas em memória.
+func installedOwners() *ownerIndex {
+	ownersOnce.Do(func() {
+		pkgdb, _ := readPkgdb()
+		owners = buildOwnerIndex(pkgdb)
+	})
+	return owners
+}
+
+func buildOwnerIndex(pkgdb map[string]*PkgdbEntry) *ownerIndex {
+	ix := &ownerIndex{owners: make(map[string][]fileOwner), byBase: make(map[string][]string)}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, runtime.NumCPU())
+	for name, entry := range pkgdb {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(name, pkgver string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			files, err := readPkgFiles(name)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			groups := []struct {
+				kind  string
+				files []PkgFile
+			}{{"file", files.Files}, {"conf", files.ConfFiles}, {"link", files.Links}, {"dir", files.Dirs}}
+			for _, g := range groups {
+				for _, f := range g.files {
+					if _, ok := ix.owners[f.File]; !ok {
+						base := filepath.Base(f.File)
+						ix.byBase[base] = append(ix.byBase[base], f.File)
+					}
+					ix.owners[f.File] = append(ix.owners[f.File], fileOwner{Pkgver: pkgver, Type: g.kind, Target: f.Target, Sha256: f.Sha256})
+				}
+			}
+		}(name, entry.Pkgver)
+	}
+	wg.Wait()
+	return ix
+}
+
+// fileMatcher decide, a partir do formato da consulta, como comparar:
+// "/usr/bin/ls" caminho exato, "ls" nome base, "*.so.*" ou "/usr/lib/*.a"
+// glob (no nome base se não houver '/') e "re:^/etc/.*\.conf$" regex.
+type fileMatcher struct {
+	exact string
+	base  string
+	glob  string
+	re    *regexp.Regexp
+}
+
+func newFileMatcher(query string) (*fileMatcher, error) {
+	switch {
+	case strings.HasPrefix(query, "re:"):
+		re, err := regexp.Compile(strings.TrimPrefix(query, "re:"))
+		if err != nil {
+			return nil, fmt.Errorf("regex inválida: %w", err)
+		}
+		return &fileMatcher{re: re}, nil
+	case strings.ContainsAny(query, "*?["):
+		if _, err := filepath.Match(query, ""); err != nil {
+			return nil, fmt.Errorf("glob inválido: %w", err)
+		}
+		return &fileMatcher{glob: query}, nil
+	case strings.HasPrefix(query, "/"):
+		return &fileMatcher{exact: realPath(query)}, nil
+	}
+	return &fileMatcher{base: query}, nil
+}
+
+// realPath resolve os links do diretório do caminho dentro do rootdir, já
+// que o files.plist grava o destino: no Void /bin, /sbin e /lib apontam
+// para /usr, e "-F /bin/ls" tem que achar /usr/bin/ls.
+func realPath(query string) string {
+	path := filepath.Clean(query)
+	root := "/"
+	if rootDir != "" {
+		root, _ = filepath.Abs(rootDir)
+	}
+	root, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return path
+	}
+	dir, err := filepath.EvalSymlinks(filepath.Join(root, filepath.Dir(path)))
+	if err != nil {
+		return path
+	}
+	rel, err := filepath.Rel(root, dir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return path
+	}
+	return filepath.Join("/", rel, filepath.Base(path))
+}
+
+func (m *fileMatcher) match(path string) bool {
+	switch {
+	case m.re != nil:
+		return m.re.MatchString(path)
+	case m.glob != "":
+		if !strings.Contains(m.glob, "/") {
+			path = filepath.Base(path)
+		}
+		ok, _ := filepath.Match(m.glob, path)
+		return ok
+	case m.exact != "":
+		return path == m.exact
+	}
+	return filepath.Base(path) == m.base
+}
+
+// find usa o acesso direto para caminho exato e nome base; glob e regex
+// percorrem o índice inteiro.
+func (ix *ownerIndex) find(m *fileMatcher) []fileMatch {
+	var paths []string
+	switch {
+	case m.exact != "":
+		paths = []string{m.exact}
+	case m.base != "":
+		paths = ix.byBase[m.base]
+	default:
+		for path := range ix.owners {
+			if m.match(path) {
+				paths = append(paths, path)
+			}
+		}
+	}
+	sort.Strings(paths)
+
+	var found []fileMatch
+	for _, path := range paths {
+		for _, o := range ix.owners[path] {
+			found = append(found, fileMatch{Pkgver: o.Pkgver, Path: path, Type: o.Type, Target: o.Target, Source: "pkgdb"})
+		}
+	}
+	return found
+}
+
+// --- ÍNDICE REMOTO DE ARQUIVOS (--update-file-index) ---
+
+const fileIndexFormat = 1
+
+// fileIndex é o índice caminho → pacote usado pelo -FR sem rede. Guarda os
+// caminhos em fatias paralelas (Paths/Owners/Types) em vez de structs para
+// que o gob de alguns milhões de entradas continue rápido de decodificar.
+type fileIndex struct {
+	Format  int
+	Arch    string
+	Built   int64
+	Sources []string
+	Pkgvers []string
+	Paths   []string
+	Owners  []int32
+	Types   []uint8
+}
+
+var fileTypes = []string{"", "file", "conf", "link"}
+
+func fileIndexPath(arch string) string {
+	return filepath.Join(indexCacheDir(), "files-"+arch+".idx")
+}
+
+func loadFileIndex(arch string) (*fileIndex, error) {
+	var fi fileIndex
+	if err := readCache(fileIndexPath(arch), &fi); err != nil {
+		return nil, err
+	}
+	if fi.Format != fileIndexFormat {
+		return nil, fmt.Errorf("índice de arquivos em formato antigo")
+	}
+	return &fi, nil
+}
+
+func (fi *fileIndex) find(m *fileMatcher) []fileMatch {
+	var found []fileMatch
+	for i, path := range fi.Paths {
+		if m.match(path) {
+			found = append(found, fileMatch{Pkgver: fi.Pkgvers[fi.Owners[i]], Path: path, Type: fileTypes[fi.Types[i]], Source: "index"})
+		}
+	}
+	return found
+}
+
+// filesOf devolve os caminhos registrados para um pkgver exato.
+func (fi *fileIndex) filesOf(pkgver string) []string {
+	owner := int32(-1)
+	for i, p := range fi.Pkgvers {
+		if p == pkgver {
+			owner = int32(i)
+			break
+		}
+	}
+	var paths []string
+	for i, o := range fi.Owners {
+		if o == owner {
+			paths = append(paths, fi.Paths[i])
+		}
+	}
+	return paths
+}
+
+// fileIndexBuilder acumula entradas mantendo só a maior versão de cada
+// pacote; um cache com firefox-127 e firefox-128 não deve responder pelos dois.
+type fileIndexBuilder struct {
+	pkgs map[string]*indexedPkg
+}
+
+type indexedPkg struct {
+	pkgver string
+	paths  []string
+	types  []uint8
+}
+
+func (b *fileIndexBuilder) add(p *indexedPkg) {
+	name := patternName(p.pkgver)
+	if cur, ok := b.pkgs[name]; ok && cmpVersion(cur.pkgver, p.pkgver) > 0 {
+		return
+	}
+	b.pkgs[name] = p
+}
+
+func (b *fileIndexBuilder) build(arch string, sources []string) *fileIndex {
+	fi := &fileIndex{Format: fileIndexFormat, Arch: arch, Built: time.Now().Unix(), Sources: sources}
+	names := make([]string, 0, len(b.pkgs))
+	for name := range b.pkgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		p := b.pkgs[name]
+		fi.Pkgvers = append(fi.Pkgvers, p.pkgver)
+		fi.Paths = append(fi.Paths, p.paths...)
+		fi.Types = append(fi.Types, p.types...)
+		for range p.paths {
+			fi.Owners = append(fi.Owners, int32(i))
+		}
+	}
+	return fi
+}
+
+// addArchiveDir lê o files.plist de cada .xbps da arquitetura alvo (ou
+// noarch) encontrado no diretório, em paralelo.
+func (b *fileIndexBuilder) addArchiveDir(dir, arch string) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, runtime.NumCPU())
+	count := 0
+	for _, e := range entries {
+		pkgver, fileArch, ok := archivePkgver(e.Name())
+		if e.IsDir() || !ok || (fileArch != arch && fileArch != "noarch") {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(path, pkgver string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			files, err := readArchiveFiles(path)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s %v\n", yellow("[!]"), err)
+				return
+			}
+			p := &indexedPkg{pkgver: pkgver}
+			for t, group := range [][]PkgFile{files.Files, files.ConfFiles, files.Links} {
+				for _, f := range group {
+					p.paths = append(p.paths, f.File)
+					p.types = append(p.types, uint8(t+1))
+				}
+			}
+			mu.Lock()
+			b.add(p)
+			count++
+			mu.Unlock()
+		}(filepath.Join(dir, e.Name()), pkgver)
+	}
+	wg.Wait()
+	return count, nil
+}
+
+// addTextDump aceita a saída do xlocate ("pkgver<TAB>caminho" por linha),
+// opcionalmente comprimida com gzip, xz ou zstd.
+func (b *fileIndexBuilder) addTextDump(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	reader, err := repodata.Decompress(file)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+	defer reader.Close()
+	pkgs := make(map[string]*indexedPkg)
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		pkgver, rest, ok := strings.Cut(scanner.Text(), "\t")
+		if !ok {
+			continue
+		}
+		pkgver = strings.TrimSpace(pkgver)
+		file, _, _ := strings.Cut(strings.TrimSpace(rest), " -> ")
+		if pkgver == "" || !strings.HasPrefix(file, "/") {
+			continue
+		}
+		p := pkgs[pkgver]
+		if p == nil {
+			p = &indexedPkg{pkgver: pkgver}
+			pkgs[pkgver] = p
+		}
+		p.paths = append(p.paths, file)
+		p.types = append(p.types, 0)
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, p := range pkgs {
+		b.add(p)
+	}
+	return len(pkgs), nil
+}
+
+// updateFileIndex reconstrói o índice a partir das fontes dadas; sem fontes,
+// usa o cache do xbps e os repositórios locais configurados.
+func updateFileIndex(sources []string) {
+	arch := repoArch()
+	if len(sources) == 0 {
+		sources = []string{xbpsCacheDir}
+		for _, uri := range repodata.Repositories() {
+			uri = strings.TrimPrefix(uri, "file://")
+			if strings.HasPrefix(uri, "/") {
+				sources = append(sources, uri)
+			}
+		}
+	}
+
+	b := &fileIndexBuilder{pkgs: make(map[string]*indexedPkg)}
+	var used []string
+	for _, src := range sources {
+		info, err := os.Stat(src)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s %v\n", yellow("[!]"), err)
+			continue
+		}
+		var n int
+		if info.IsDir() {
+			n, err = b.addArchiveDir(src, arch)
+		} else {
+			n, err = b.addTextDump(src)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s %v\n", yellow("[!]"), err)
+			continue
+		}
+		fmt.Printf("%s %s %s\n", cyan(">>>"), white(src+":"), yellow(fmt.Sprintf("%d pacotes", n)))
+		used = append(used, src)
+	}
+	if len(b.pkgs) == 0 {
+		fmt.Fprintf(os.Stderr, "%s %s\n", red("[!]"), white("Nenhuma lista de arquivos encontrada nas fontes."))
+		os.Exit(1)
+	}
+
+	fi := b.build(arch, used)
+	path := fileIndexPath(arch)
+	if err := writeCache(path, fi); err != nil {
+		fmt.Fprintf(os.Stderr, "%s %v\n", red("[!]"), err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s %s %s\n", green("[OK]"), white(fmt.Sprintf("%d pacotes, %d caminhos em", len(fi.Pkgvers), len(fi.Paths))), yellow(path))
+}
+
+// --- TUI (--tui) ---
+
+// tuiItem junta o pacote do repositório e o registro do pkgdb; um dos dois
+// pode faltar (pacote não instalado ou instalado de fora dos repositórios).
+type tuiItem struct {
+	name  string
+	repo  *repodata.Package
+	local *PkgdbEntry
+}
+
+func (it *tuiItem) pkgver() string {
+	if it.repo != nil {
+		return it.repo.Pkgver
+	}
+	return it.local.Pkgver
+}
+
+func (it *tuiItem) desc() string {
+	if it.repo != nil {
+		return it.repo.ShortDesc
+	}
+	return it.local.ShortDesc
+}
+
+type tuiState struct {
+	items     []*tuiItem
+	filtered  []*tuiItem
+	cursor    int
+	offset    int
+	filter    string
+	filtering bool
+	reviewing bool
+	marks     map[string]byte
+	files     map[string][]string
+	message   string
+}
+
+func runTUI(initialFilter string, flags []string) {
+	index := loadPackageIndex(repoArch())
+	pkgdb, _ := readPkgdb()
+	if len(index.Repos) == 0 && len(pkgdb) == 0 {
+		fmt.Printf("%s %s\n", red("[!]"), white("Nenhum repodata ou pkgdb disponível."))
+		return
+	}
+
+	byName := make(map[string]*tuiItem)
+	for _, pkg := range index.All() {
+		if _, ok := byName[pkg.Name]; !ok {
+			byName[pkg.Name] = &tuiItem{name: pkg.Name, repo: pkg}
+		}
+	}
+	for name, entry := range pkgdb {
+		if it, ok := byName[name]; ok {
+			it.local = entry
+		} else {
+			byName[name] = &tuiItem{name: name, local: entry}
+		}
+	}
+	st := &tuiState{filter: initialFilter, marks: make(map[string]byte), files: make(map[string][]string)}
+	for _, it := range byName {
+		st.items = append(st.items, it)
+	}
+	sort.Slice(st.items, func(i, j int) bool { return st.items[i].name < st.items[j].name })
+	st.applyFilter()
+
+	old, err := makeRaw(syscall.Stdin)
+	if err != nil {
+		fmt.Printf("%s %s\n", red("[!]"), white("O modo --tui requer um terminal interativo."))
+		return
+	}
+	fmt.Print("\033[?1049h\033[?25l")
+	restored := false
+	restore := func() {
+		if !restored {
+			restored = true
+			fmt.Print("\033[?25h\033[?1049l")
+			setTermios(syscall.Stdin, old)
+		}
+	}
+	// Um panic não pode deixar o terminal em modo raw.
+	defer restore()
+
+	keys := make(chan string)
+	stop := make(chan struct{})
+	var reader sync.WaitGroup
+	reader.Add(1)
+	go func() {
+		defer reader.Done()
+		readKeys(keys, stop)
+	}()
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	defer signal.Stop(winch)
+
+	confirmed := false
+	for done := false; !done; {
+		st.render()
+		select {
+		case <-winch:
+			continue
+		case key, ok := <-keys:
+			if !ok {
+				done = true
+				break
+			}
+			done, confirmed = st.handleKey(key)
+		}
+	}
+	// O leitor precisa sair antes do terminal voltar ao normal, senão a
+	// leitura pendente engole a primeira linha digitada no sudo.
+	close(stop)
+	reader.Wait()
+	restore()
+
+	if !confirmed {
+		return
+	}
+	var install, remove []string
+	for _, it := range st.items {
+		switch st.marks[it.name] {
+		case 'i':
+			install = append(install, it.name)
+		case 'r':
+			remove = append(remove, it.name)
+		}
+	}
+	if len(install) > 0 && runBinary("xbps-install", flags, install) {
+		for _, name := range install {
+			checkAndEnableService(name)
+		}
+	}
+	if len(remove) > 0 {
+		runBinary("xbps-remove", removeFlags(flags), remove)
+	}
+}
+
+func (st *tuiState) applyFilter() {
+	st.filtered = st.filtered[:0]
+	words := strings.Fields(strings.ToLower(st.filter))
+	for _, it := range st.items {
+		text := strings.ToLower(it.pkgver() + " " + it.desc())
+		ok := true
+		for _, w := range words {
+			if !strings.Contains(text, w) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			st.filtered = append(st.filtered, it)
+		}
+	}
+	st.cursor, st.offset = 0, 0
+}
+
+// handleKey devolve (sair, confirmar transação).
+func (st *tuiState) handleKey(key string) (bool, bool) {
+	st.message = ""
+	rows, _ := getTerminalSize()
+	page := max(1, rows-tuiDetailLines-4)
+
+	if st.reviewing {
+		switch key {
+		case "y", "s":
+			return true, true
+		default:
+			st.reviewing = false
+		}
+		return false, false
+	}
+
+	if st.filtering {
+		switch key {
+		case "enter", "esc":
+			st.filtering = false
+		case "backspace":
+			if r := []rune(st.filter); len(r) > 0 {
+				st.filter = string(r[:len(r)-1])
+				st.applyFilter()
+			}
+		case "ctrl-c":
+			return true, false
+		default:
+			if len([]rune(key)) == 1 {
+				st.filter += key
+				st.applyFilter()
+			}
+		}
+		return false, false
+	}
+
+	switch key {
+	case "q", "ctrl-c":
+		return true, false
+	case "/":
+		st.filtering = true
+	case "esc":
+		if st.filter != "" {
+			st.filter = ""
+			st.applyFilter()
+		}
+	case "up", "k":
+		st.cursor--
+	case "down", "j":
+		st.cursor++
+	case "pgup":
+		st.cursor -= page
+	case "pgdn":
+		st.cursor += page
+	case "home", "g":
+		st.cursor = 0
+	case "end", "G":
+		st.cursor = len(st.filtered) - 1
+	case " ", "i", "r":
+		if len(st.filtered) == 0 {
+			break
+		}
+		it := st.filtered[st.cursor]
+		mark := byte('i')
+		if it.local != nil {
+			mark = 'r'
+		}
+		if key != " " {
+			mark = key[0]
+		}
+		switch {
+		case mark == 'r' && it.local == nil:
+			st.message = it.name + " não está instalado"
+		case st.marks[it.name] == mark:
+			delete(st.marks, it.name)
+		default:
+			st.marks[it.name] = mark
+		}
+		st.cursor++
+	case "enter":
+		if len(st.marks) == 0 {
+			st.message = "Nenhum pacote marcado (espaço marca/desmarca)"
+		} else {
+			st.reviewing = true
+		}
+	}
+	if st.cursor >= len(st.filtered) {
+		st.cursor = len(st.filtered) - 1
+	}
+	if st.cursor < 0 {
+		st.cursor = 0
+	}
+	return false, false
+}
+
+// tuiDetailLines é a altura do painel de detalhes abaixo da lista.
+const tuiDetailLines = 8
+
+func (st *tuiState) render() {
+	rows, cols := getTerminalSize()
+	var b strings.Builder
+	b.WriteString("\033[H")
+	line := func(s string) {
+		b.WriteString(s)
+		b.WriteString("\033[K\r\n")
+	}
+
+	header := fmt.Sprintf(" vinstall --tui │ %d/%d pacotes │ %d marcados", len(st.filtered), len(st.items), len(st.marks))
+	if st.filter != "" || st.filtering {
+		header += " │ filtro: " + st.filter
+	}
+	line(reverse(fitWidth(header, cols)))
+
+	listRows := max(1, rows-tuiDetailLines-4)
+	if st.reviewing {
+		st.renderReview(line, listRows, cols)
+	} else {
+		if st.cursor < st.offset {
+			st.offset = st.cursor
+		}
+		if st.cursor >= st.offset+listRows {
+			st.offset = st.cursor - listRows + 1
+		}
+		for i := 0; i < listRows; i++ {
+			n := st.offset + i
+			if n >= len(st.filtered) {
+				line("")
+				continue
+			}
+			it := st.filtered[n]
+			mark := "   "
+			switch st.marks[it.name] {
+			case 'i':
+				mark = green_bold("[I]")
+			case 'r':
+				mark = red("[R]")
+			}
+			status := red("[-]")
+			if it.local != nil {
+				status = green("[✔]")
+			}
+			text := fitWidth(fmt.Sprintf("%-32s %s", it.pkgver(), it.desc()), cols-10)
+			if n == st.cursor {
+				line(">" + mark + " " + status + " " + reverse(text))
+			} else {
+				line(" " + mark + " " + status + " " + text)
+			}
+		}
+	}
+
+	line(white(strings.Repeat("─", cols)))
+	details := st.details(cols)
+	for i := 0; i < tuiDetailLines; i++ {
+		if i < len(details) {
+			line(details[i])
+		} else {
+			line("")
+		}
+	}
+	line(white(strings.Repeat("─", cols)))
+
+	footer := "↑↓ PgUp/PgDn navegar  / filtrar  espaço marcar  i/r instalar/remover  Enter revisar  q sair"
+	switch {
+	case st.message != "":
+		footer = yellow(fitWidth(st.message, cols))
+	case st.filtering:
+		footer = fitWidth("Filtro: "+st.filter+"█  (Enter conclui, Esc fecha)", cols)
+	case st.reviewing:
+		footer = fitWidth("Confirmar transação? [s/N]", cols)
+	default:
+		footer = fitWidth(footer, cols)
+	}
+	b.WriteString(footer)
+	b.WriteString("\033[K")
+	os.Stdout.WriteString(b.String())
+}
+
+func (st *tuiState) renderReview(line func(string), listRows, cols int) {
+	var install, remove []*tuiItem
+	var down, delta int64
+	for _, it := range st.items {
+		switch st.marks[it.name] {
+		case 'i':
+			install = append(install, it)
+			if it.repo != nil {
+				down += it.repo.FilenameSize
+				delta += it.repo.InstalledSize
+			}
+		case 'r':
+			remove = append(remove, it)
+			delta -= it.local.InstalledSize
+		}
+	}
+	var out []string
+	out = append(out, cyan("Revisão da transação:"))
+	for _, it := range install {
+		out = append(out, "  "+green("+ ")+white(it.pkgver()))
+	}
+	for _, it := range remove {
+		out = append(out, "  "+red("- ")+white(it.pkgver()))
+	}
+	out = append(out, "", fmt.Sprintf("  Download: %s   Espaço em disco: %s", formatBytes(down), formatSignedBytes(delta)))
+	for i := 0; i < listRows; i++ {
+		if i < len(out) {
+			line(out[i])
+		} else {
+			line("")
+		}
+	}
+}
+
+func (st *tuiState) details(cols int) []string {
+	if len(st.filtered) == 0 {
+		return []string{white("Nenhum pacote corresponde ao filtro.")}
+	}
+	it := st.filtered[st.cursor]
+	var out []string
+	add := func(label, value string) {
+		out = append(out, cyan(label)+" "+fitWidth(value, cols-len([]rune(label))-1))
+	}
+	out = append(out, white(fitWidth(it.pkgver()+" — "+it.desc(), cols)))
+	if it.repo != nil {
+		add("Repositório:", it.repo.Repo+" │ "+it.repo.Maintainer)
+		add("Licença:", it.repo.License+" │ "+it.repo.Homepage)
+		add("Tamanho:", formatBytes(it.repo.FilenameSize)+" download / "+formatBytes(it.repo.InstalledSize)+" instalado")
+		add("Dependências:", strings.Join(it.repo.RunDepends, ", "))
+	} else {
+		add("Repositório:", it.local.Repository+" (não está nos repositórios ativos)")
+		add("Tamanho:", formatBytes(it.local.InstalledSize)+" instalado")
+		add("Dependências:", strings.Join(it.local.RunDepends, ", "))
+	}
+	if it.local != nil {
+		if it.repo != nil && it.repo.Pkgver != it.local.Pkgver {
+			add("Instalado:", it.local.Pkgver)
+		}
+		files, ok := st.files[it.name]
+		if !ok {
+			if pf, err := readPkgFiles(it.name); err == nil {
+				for _, group := range [][]PkgFile{pf.Files, pf.ConfFiles, pf.Links} {
+					for _, f := range group {
+						files = append(files, f.File)
+					}
+				}
+				sort.Strings(files)
+			}
+			st.files[it.name] = files
+		}
+		add(fmt.Sprintf("Arquivos (%d):", len(files)), strings.Join(files, " "))
+	} else {
+		add("Arquivos:", "disponíveis após a instalação")
+	}
+	return out
+}
+
+func formatSignedBytes(b int64) string {
+	if b < 0 {
+		return "-" + formatBytes(-b)
+	}
+	return "+" + formatBytes(b)
+}
+
+// fitWidth corta ou completa s com espaços até width colunas (runas).
+func fitWidth(s string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	r := []rune(s)
+	if len(r) > width {
+		if width > 1 {
+			return string(r[:width-1]) + "…"
+		}
+		return string(r[:width])
+	}
+	return s + strings.Repeat(" ", width-len(r))
+}
+
+// readKeys traduz a entrada em modo raw para nomes de teclas, incluindo as
+// sequências de escape das setas, PgUp/PgDn e Home/End.
+func readKeys(keys chan<- string, stop <-chan struct{}) {
+	defer close(keys)
+	buf := make([]byte, 64)
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		// Com VMIN=0 e VTIME=1 o Read volta vazio a cada 100ms, o que dá
+		// chance de conferir o stop.
+		n, err := os.Stdin.Read(buf)
+		if n == 0 {
+			if err != nil && err != io.EOF {
+				return
+			}
+			continue
+		}
+		in := buf[:n]
+		for len(in) > 0 {
+			key, size := decodeKey(in)
+			in = in[size:]
+			select {
+			case keys <- key:
+			case <-stop:
+				return
+			}
+		}
+	}
+}
+
+var escapeKeys = map[string]string{
+	"\x1b[A": "up", "\x1b[B": "down", "\x1b[C": "right", "\x1b[D": "left",
+	"\x1bOA": "up", "\x1bOB": "down",
+	"\x1b[5~": "pgup", "\x1b[6~": "pgdn",
+	"\x1b[H": "home", "\x1b[F": "end", "\x1b[1~": "home", "\x1b[4~": "end",
+	"\x1bOH": "home", "\x1bOF": "end",
+}
+
+func decodeKey(in []byte) (string, int) {
+	switch in[0] {
+	case 0x1b:
+		for seq, name := range escapeKeys {
+			if bytes.HasPrefix(in, []byte(seq)) {
+				return name, len(seq)
+			}
+		}
+		if len(in) > 1 && (in[1] == '[' || in[1] == 'O') {
+			// sequência desconhecida: descarta até o byte final
+			i := 2
+			for i < len(in) && (in[i] < 0x40 || in[i] > 0x7e) {
+				i++
+			}
+			return "", min(i+1, len(in))
+		}
+		return "esc", 1
+	case '\r', '\n':
+		return "enter", 1
+	case 0x7f, 0x08:
+		return "backspace", 1
+	case 0x03:
+		return "ctrl-c", 1
+	}
+	r, size := utf8.DecodeRune(in)
+	return string(r), size
+}
+
+func makeRaw(fd int) (*syscall.Termios, error) {
+	var old syscall.Termios
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TCGETS, uintptr(unsafe.Pointer(&old))); errno != 0 {
+		return nil, errno
+	}
+	raw := old
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 0
+	raw.Cc[syscall.VTIME] = 1
+	if err := setTermios(fd, &raw); err != nil {
+		return nil, err
+	}
+	return &old, nil
+}
+
+func setTermios(fd int, t *syscall.Termios) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TCSETS, uintptr(unsafe.Pointer(t))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// --- FUNÇÕES DE SISTEMA, LIMPEZA E FIND ---
+
+// fileMatch é um resultado de -F/-FR, vindo de uma das fontes consultadas.
+type fileMatch struct {
+	Pkgver string `json:"pkgver"`
+	Path   string `json:"path"`
+	Type   string `json:"type"`
+	Target string `json:"target"`
+	Source string `json:"source"`
+}
+
+func findProvides(file string, searchRemote bool) {
+	plain := outputFormat == "plain"
+	match, err := newFileMatcher(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s %v\n", red("[!]"), err)
+		return
+	}
+	if plain {
+		fmt.Printf("%s %s '%s'...\n", cyan("[vinstall]"), white("Procurando pacote que contém:"), yellow(file))
+		fmt.Printf("%s %s %s\n", cyan(">>>"), cyan("pkgdb /var/db/xbps/.*-files.plist"), yellow(file))
+	}
+
+	// O índice próprio responde o -FR sem rede, mas costuma cobrir só o
+	// cache e os repositórios locais; sem resultado nele, xlocate e
+	// xbps-query -Ro continuam valendo.
+	var files *fileIndex
+	if searchRemote {
+		files, _ = loadFileIndex(repoArch())
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var matches []fileMatch
+
+	emit := func(found []fileMatch) {
+		mu.Lock()
+		defer mu.Unlock()
+		matches = append(matches, found...)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		emit(installedOwners().find(match))
+	}()
+
+	if files != nil {
+		if plain {
+			fmt.Printf("%s %s %s\n", cyan(">>>"), cyan(fileIndexPath(files.Arch)), yellow(file))
+			if time.Since(time.Unix(files.Built, 0)).Hours() > 168 {
+				fmt.Printf("%s %s\n", yellow("[TIP]"), white("Índice de arquivos antigo. Considere 'vinstall --update-file-index'."))
+			}
+		}
+		found := files.find(match)
+		emit(found)
+		if len(found) > 0 {
+			wg.Wait()
+			printFileMatches(matches)
+			return
+		}
+	}
+
+	xPath, xlocateErr := exec.LookPath("xlocate")
+	if xlocateErr == nil {
+		if plain {
+			checkXlocateIndex()
+			fmt.Printf("%s %s %s\n", cyan(">>>"), cyan("xlocate"), yellow(file))
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), execTimeout)
+			defer cancel()
+			output, _ := exec.CommandContext(ctx, xPath, file).Output()
+			var found []fileMatch
+			seen := make(map[string]bool)
+			for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+				if line == "" || seen[line] {
+					continue
+				}
+				seen[line] = true
+				pkgver, path, _ := strings.Cut(line, "\t")
+				found = append(found, fileMatch{Pkgver: strings.TrimSpace(pkgver), Path: strings.TrimSpace(path), Source: "xlocate"})
+			}
+			emit(found)
+		}()
+	}
+
+	if searchRemote {
+		if plain {
+			fmt.Printf("%s %s %s %s\n", cyan(">>>"), cyan("xbps-query"), cyan("-Ro"), yellow(file))
+			if files == nil {
+				fmt.Printf("%s %s\n", yellow("[TIP]"), white("Use 'vinstall --update-file-index' para buscar sem rede."))
+			}
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), execTimeout)
+			defer cancel()
+			output, _ := exec.CommandContext(ctx, "xbps-query", "-Ro", file).Output()
+			emit(parseOwnedBy(string(output), "remote"))
+		}()
+	}
+
+	wg.Wait()
+	printFileMatches(matches)
+}
+
+func printFileMatches(matches []fileMatch) {
+	if writeRecords(matches) {
+		return
+	}
+	for _, m := range matches {
+		line := m.Pkgver + ": " + m.Path
+		if m.Target != "" {
+			line += " -> " + m.Target
+		}
+		if m.Type != "" {
+			line += " (" + m.Type + ")"
+		}
+		if m.Source == "pkgdb" {
+			line += " (instalado)"
+		}
+		fmt.Println(green(line))
+	}
+	if len(matches) == 0 {
+		fmt.Printf("%s %s\n", red("[!]"), white("Nenhum pacote encontrado. Use -FR para busca profunda."))
+	}
+}
+
+// parseOwnedBy interpreta a saída do xbps-query -o/-Ro, no formato
+// "pkgver: /caminho (tipo)".
+func parseOwnedBy(output, source string) []fileMatch {
+	var found []fileMatch
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		pkgver, rest, ok := strings.Cut(line, ": ")
+		if !ok {
+			continue
+		}
+		m := fileMatch{Pkgver: pkgver, Path: rest, Source: source}
+		if i := strings.LastIndex(rest, " ("); i > 0 && strings.HasSuffix(rest, ")") {
+			m.Path, m.Type = rest[:i], rest[i+2:len(rest)-1]
+		}
+		found = append(found, m)
+	}
+	return found
+}
+
+// localRecord é uma linha do xbps-query -l/-O em formato estruturado.
+type localRecord struct {
+	State       string `json:"state"`
+	Pkgver      string `json:"pkgver"`
+	Description string `json:"description"`
+}
+
+func listLocal(mode string, query string) {
+	plain := outputFormat == "plain"
+	var cmd *exec.Cmd
+	switch mode {
+	case "installed":
+		if plain {
+			fmt.Printf("%s %s\n", cyan("[vinstall]"), white("Listando pacotes instalados:"))
+		}
+		cmd = exec.Command("xbps-query", "-l")
+	case "orphans":
+		if plain {
+			fmt.Printf("%s %s\n", cyan("[vinstall]"), white("Listando pacotes órfãos:"))
+		}
+		cmd = exec.Command("xbps-query", "-O")
+	case "search":
+		if plain {
+			fmt.Printf("%s %s '%s'...\n", cyan("[vinstall]"), white("Buscando localmente por:"), yellow(query))
+		}
+		cmd = exec.Command("xbps-query", "-l")
+	}
+
+	output, _ := cmd.Output()
+	lines := strings.Split(string(output), "\n")
+
+	var records []localRecord
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+		if mode == "search" && !strings.Contains(strings.ToLower(line), strings.ToLower(query)) {
+			continue
+		}
+		fields := strings.Fields(line)
+		rec := localRecord{Pkgver: fields[0]}
+		if mode != "orphans" && len(fields) >= 2 {
+			rec.State, rec.Pkgver = fields[0], fields[1]
+			rec.Description = strings.Join(fields[2:], " ")
+		}
+		records = append(records, rec)
+	}
+	if writeRecords(records) {
+		return
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+
+	count := 0
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+		if mode == "search" && !strings.Contains(strings.ToLower(line), strings.ToLower(query)) {
+			continue
+		}
+		fmt.Fprintln(w, white(line))
+		count++
+	}
+	fmt.Fprintf(w, "\n%s %s: %s\n", yellow("[!]"), white("Total:"), cyan(strconv.Itoa(count)))
+}
+
+// cachePolicy são as regras do -Scc. O padrão mantém as 3 versões mais
+// recentes de cada pacote e sempre a instalada; --all volta ao comportamento
+// antigo de apagar tudo. Com --older-than a idade decide: arquivos mais
+// velhos saem mesmo entre os N mais recentes, e o limite de versões só vale
+// se --keep também for dado.
+type cachePolicy struct {
+	Keep          int // -1: não informado
+	All           bool
+	UninstalledOn bool
+	OlderThan     time.Duration
+	MaxSize       int64
+	DryRun        bool
+}
+
+// cacheRecord é um arquivo que o -Scc removeu (ou removeria, em --dry-run).
+type cacheRecord struct {
+	Name   string `json:"name"`
+	Pkgver string `json:"pkgver"`
+	File   string `json:"file"`
+	Size   int64  `json:"size"`
+	Reason string `json:"reason"`
+}
+
+type cachedFile struct {
+	name, pkgver, path string
+	size               int64 // .xbps + .sig2
+	mtime              time.Time
+	installed          bool
+}
+
+// archivePkgver separa "foo-1.0_1.x86_64.xbps" em pkgver e arquitetura.
+func archivePkgver(filename string) (string, string, bool) {
+	base := strings.TrimSuffix(filename, ".xbps")
+	dot := strings.LastIndex(base, ".")
+	if base == filename || dot < 0 {
+		return "", "", false
+	}
+	return base[:dot], base[dot+1:], true
+}
+
+// planCacheClean aplica a política e devolve o que sai do cache, incluindo
+// .sig2 órfãos (sem o .xbps correspondente), que nunca servem para nada.
+func planCacheClean(dir string, policy cachePolicy, pkgdb map[string]*PkgdbEntry) ([]cacheRecord, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	present := make(map[string]bool)
+	for _, e := range entries {
+		present[e.Name()] = true
+	}
+
+	var records []cacheRecord
+	groups := make(map[string][]*cachedFile)
+	for _, e := range entries {
+		name := e.Name()
+		info, err := e.Info()
+		if err != nil || e.IsDir() {
+			continue
+		}
+		if strings.HasSuffix(name, ".sig2") {
+			if !present[strings.TrimSuffix(name, ".sig2")] {
+				records = append(records, cacheRecord{File: filepath.Join(dir, name), Size: info.Size(), Reason: "orphan-sig2"})
+			}
+			continue
+		}
+		pkgver, _, ok := archivePkgver(name)
+		if !ok {
+			continue
+		}
+		f := &cachedFile{name: patternName(pkgver), pkgver: pkgver, path: filepath.Join(dir, name), size: info.Size(), mtime: info.ModTime()}
+		if sig, err := os.Stat(f.path + ".sig2"); err == nil {
+			f.size += sig.Size()
+		}
+		if cur := pkgdb[f.name]; cur != nil && cur.Pkgver == pkgver {
+			f.installed = true
+		}
+		groups[f.name] = append(groups[f.name], f)
+	}
+
+	remove := func(f *cachedFile, reason string) {
+		records = append(records, cacheRecord{Name: f.name, Pkgver: f.pkgver, File: f.path, Size: f.size, Reason: reason})
+	}
+	cutoff := time.Now().Add(-policy.OlderThan)
+	keep := policy.Keep
+	if keep < 0 {
+		keep = 3
+		if policy.OlderThan > 0 {
+			keep = math.MaxInt
+		}
+	}
+	var kept []*cachedFile
+	var keptSize int64
+	for _, name := range sortedKeys(groups) {
+		files := groups[name]
+		sort.Slice(files, func(i, j int) bool { return cmpVersion(files[i].pkgver, files[j].pkgver) > 0 })
+		recent := 0
+		for _, f := range files {
+			reason := ""
+			switch {
+			case policy.All:
+				reason = "all"
+			case f.installed:
+			case policy.UninstalledOn && pkgdb[name] != nil:
+			case policy.OlderThan > 0 && f.mtime.Before(cutoff):
+				reason = "older-than"
+			case recent < keep:
+				recent++
+			case policy.UninstalledOn:
+				reason = "uninstalled"
+			default:
+				reason = "old-version"
+			}
+			if reason != "" {
+				remove(f, reason)
+				continue
+			}
+			kept = append(kept, f)
+			keptSize += f.size
+		}
+	}
+
+	// O teto de tamanho remove os mais antigos primeiro, mas nunca a versão
+	// instalada: ela é a que um rollback ou reinstalação mais precisa.
+	if policy.MaxSize > 0 && keptSize > policy.MaxSize {
+		sort.Slice(kept, func(i, j int) bool { return kept[i].mtime.Before(kept[j].mtime) })
+		for _, f := range kept {
+			if keptSize <= policy.MaxSize {
+				break
+			}
+			if f.installed {
+				continue
+			}
+			remove(f, "max-size")
+			keptSize -= f.size
+		}
+	}
+	return records, nil
+}
+
+func (p *cachePolicy) set(opt, value string) error {
+	var err error
+	switch opt {
+	case "--keep":
+		if p.Keep, err = strconv.Atoi(value); err != nil || p.Keep < 0 {
+			return fmt.Errorf("--keep inválido: %q", value)
+		}
+	case "--older-than":
+		if n, convErr := strconv.Atoi(strings.TrimSuffix(value, "d")); convErr == nil && strings.HasSuffix(value, "d") {
+			p.OlderThan = time.Duration(n) * 24 * time.Hour
+		} else if p.OlderThan, err = time.ParseDuration(value); err != nil {
+			return fmt.Errorf("--older-than inválido: %q (ex: 30d, 12h)", value)
+		}
+	case "--max-size":
+		if p.MaxSize, err = parseSize(value); err != nil {
+			return fmt.Errorf("--max-size: %w", err)
+		}
+	}
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func cleanXbpsCache(policy cachePolicy) {
+	if os.Geteuid() != 0 && !policy.DryRun {
+		fmt.Printf("%s %s\n", yellow("[vinstall]"), white("A limpeza do cache requer privilégios de root."))
+		cmd := exec.Command("sudo", os.Args...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cmd.Stdin = os.Stdin
+		cmd.Run()
+		return
+	}
+
+	pkgdb, _ := readPkgdb()
+	records, err := planCacheClean(xbpsCacheDir, policy, pkgdb)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s %v\n", red("[!]"), err)
+		return
+	}
+	if !policy.DryRun {
+		for _, r := range records {
+			os.Remove(r.File)
+			if r.Reason != "orphan-sig2" {
+				os.Remove(r.File + ".sig2")
+			}
+		}
+	}
+	if writeRecords(records) {
+		return
+	}
+
+	if policy.DryRun {
+		fmt.Printf("%s %s\n", cyan("[vinstall]"), white("Simulação da limpeza do cache (--dry-run):"))
+	} else {
+		fmt.Printf("%s %s\n", cyan("[vinstall]"), white("Iniciando limpeza do cache..."))
+	}
+	byName := make(map[string][]cacheRecord)
+	var totalSize int64
+	var pkgCount, sigCount int
+	for _, r := range records {
+		totalSize += r.Size
+		if r.Reason == "orphan-sig2" {
+			sigCount++
+			fmt.Printf("  %s %s\n", yellow("[.sig2 órfão]"), white(filepath.Base(r.File)))
+			continue
+		}
+		pkgCount++
+		byName[r.Name] = append(byName[r.Name], r)
+	}
+	for _, name := range sortedKeys(byName) {
+		var size int64
+		var vers []string
+		for _, r := range byName[name] {
+			size += r.Size
+			vers = append(vers, strings.TrimPrefix(r.Pkgver, name+"-")+" ("+r.Reason+")")
+		}
+		fmt.Printf("  %-30s %10s  %s\n", cyan(name), green(formatBytes(size)), white(strings.Join(vers, ", ")))
+	}
+
+	if policy.DryRun {
+		fmt.Printf("\n%s %s %s\n", yellow("[!]"), white("Seriam removidos:"), cyan(fmt.Sprintf("%d pacotes, %d .sig2 órfãos", pkgCount, sigCount)))
+		fmt.Printf("%s %s %s\n", yellow("[!]"), white("Espaço a liberar:"), green(formatBytes(totalSize)))
+		return
+	}
+	fmt.Printf("\n%s %s\n", green("[✔]"), white("Limpeza concluída!"))
+	fmt.Printf("%s %s %s\n", yellow("[!]"), white("Removidos:"), cyan(fmt.Sprintf("%d pacotes, %d .sig2 órfãos", pkgCount, sigCount)))
+	fmt.Printf("%s %s %s\n", yellow("[!]"), white("Espaço livre:"), green(formatBytes(totalSize)))
+
+	reviewOrphans()
+}
+
+func checkAndEnableService(pkgName string) {
+	if serviceState(pkgName) == "disabled" {
+		fmt.Printf("\n%s %s '%s'. Ativar? [s/N]: ", yellow("[!]"), white("Serviço disponível para"), cyan(pkgName))
+		reader := bufio.NewReader(os.Stdin)
+		input, _ := reader.ReadString('\n')
+		if a := strings.ToLower(strings.TrimSpace(input)); a == "s" || a == "sim" {
+			enableService(pkgName)
+		}
+	}
+}
+
+// serviceState diz se o runit tem o serviço: "enabled" (link em
+// /var/service), "disabled" (só em /etc/sv) ou "missing".
+func serviceState(name string) string {
+	if _, err := os.Lstat(filepath.Join("/var/service", name)); err == nil {
+		return "enabled"
+	}
+	if info, err := os.Stat(filepath.Join("/etc/sv", name)); err == nil && info.IsDir() {
+		return "disabled"
+	}
+	return "missing"
+}
+
+func enableService(name string) error {
+	return exec.Command("sudo", "ln", "-s", filepath.Join("/etc/sv", name), filepath.Join("/var/service", name)).Run()
+}
+
+// --- HISTÓRICO (--history) ---
+
+const historyDir = "/var/log/socklog/xbps"
+
+// transactionGap separa transações: eventos registrados com menos de um
+// minuto de intervalo pertencem à mesma execução do xbps.
+const transactionGap = time.Minute
+
+// historyEvent é uma linha do log do xbps já interpretada.
+type historyEvent struct {
+	Time        time.Time `json:"time"`
+	Transaction string    `json:"transaction"`
+	Action      string    `json:"action"`
+	Name        string    `json:"name"`
+	Pkgver      string    `json:"pkgver"`
+	OldPkgver   string    `json:"old_pkgver"`
+}
+
+type historyFilter struct {
+	Since  time.Time
+	Until  time.Time
+	Pkg    string
+	Action string
+}
+
+// xbpsLogRe cobre as mensagens de syslog do xbps-install/xbps-remove:
+//
+//	Installed `foo-1.0_1' successfully (rootdir: /).
+//	Updated `foo-1.0_1' to `foo-1.1_1' successfully (rootdir: /).
+//	Removed `foo-1.1_1' successfully (rootdir: /).
+var xbpsLogRe = regexp.MustCompile("(Installed|Updated|Reinstalled|Downgraded|Removed) `([^']+)'(?: to `([^']+)')?")
+
+var historyActions = map[string]string{
+	"Installed":   "install",
+	"Reinstalled": "install",
+	"Updated":     "update",
+	"Downgraded":  "update",
+	"Removed":     "remove",
+}
+
+// historyFiles devolve os arquivos do svlogd em ordem cronológica: os
+// rotacionados (@<tai64n>.s/.u, cujo nome já ordena pelo tempo) e o current.
+func historyFiles() []string {
+	rotated, _ := filepath.Glob(filepath.Join(historyDir, "@*"))
+	sort.Strings(rotated)
+	return append(rotated, filepath.Join(historyDir, "current"))
+}
+
+// parseTAI64N converte "@4000000066a1b2c30a1b2c3d" (rótulo TAI64 + nanossegundos).
+// O deslocamento de 10s é a convenção do daemontools entre TAI e UTC.
+func parseTAI64N(stamp string) (time.Time, bool) {
+	if len(stamp) != 25 || stamp[0] != '@' {
+		return time.Time{}, false
+	}
+	secs, err := strconv.ParseUint(stamp[1:17], 16, 64)
+	if err != nil || secs < 1<<62 {
+		return time.Time{}, false
+	}
+	nanos, err := strconv.ParseUint(stamp[17:], 16, 32)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(secs-1<<62)-10, int64(nanos)), true
+}
+
+// parseLogStamp aceita os três formatos do svlogd: -t (TAI64N), -tt
+// (2024-07-10_12:34:56.12345) e -ttt (2024-07-10T12:34:56.12345), sempre UTC.
+func parseLogStamp(stamp string) (time.Time, bool) {
+	if t, ok := parseTAI64N(stamp); ok {
+		return t, true
+	}
+	for _, layout := range []string{"2006-01-02_15:04:05.999999999", "2006-01-02T15:04:05.999999999"} {
+		if t, err := time.ParseInLocation(layout, stamp, time.UTC); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func readHistoryFile(path string) ([]historyEvent, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader, err := repodata.Decompress(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	defer reader.Close()
+
+	var events []historyEvent
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		stamp, msg, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			continue
+		}
+		t, ok := parseLogStamp(stamp)
+		if !ok {
+			continue
+		}
+		m := xbpsLogRe.FindStringSubmatch(msg)
+		if m == nil {
+			continue
+		}
+		ev := historyEvent{Time: t.Local(), Action: historyActions[m[1]], Pkgver: m[2]}
+		if m[3] != "" {
+			ev.OldPkgver, ev.Pkgver = m[2], m[3]
+		}
+		ev.Name = patternName(ev.Pkgver)
+		events = append(events, ev)
+	}
+	return events, scanner.Err()
+}
+
+// readHistory lê todo o log e atribui a cada evento o ID da sua transação,
+// "AAAAMMDD-HHMMSS" do primeiro evento do grupo. Os IDs não dependem de
+// filtros, então podem ser usados depois em --rollback.
+func readHistory() ([]historyEvent, error) {
+	var events []historyEvent
+	var firstErr error
+	for _, path := range historyFiles() {
+		evs, err := readHistoryFile(path)
+		if err != nil {
+			if firstErr == nil && !os.IsNotExist(err) {
+				firstErr = err
+			}
+			continue
+		}
+		events = append(events, evs...)
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+
+	var id string
+	for i := range events {
+		if i == 0 || events[i].Time.Sub(events[i-1].Time) >= transactionGap {
+			id = events[i].Time.Format("20060102-150405")
+		}
+		events[i].Transaction = id
+	}
+	if len(events) == 0 && firstErr != nil {
+		return nil, firstErr
+	}
+	return events, nil
+}
+
+// parseHistoryTime aceita datas absolutas ("2024-07-10", "2024-07-10 12:30")
+// ou relativas ao agora ("36h", "7d"). Com upper, uma data sem hora vale
+// até o fim do dia.
+func parseHistoryTime(s string, upper bool) (time.Time, error) {
+	if n, err := strconv.Atoi(strings.TrimSuffix(s, "d")); err == nil && strings.HasSuffix(s, "d") {
+		return time.Now().AddDate(0, 0, -n), nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02"} {
+		t, err := time.ParseInLocation(layout, s, time.Local)
+		if err != nil {
+			continue
+		}
+		if upper && layout == "2006-01-02" {
+			t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		}
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("data inválida: %q (use AAAA-MM-DD, \"AAAA-MM-DD HH:MM\", 7d ou 12h)", s)
+}
+
+func (f historyFilter) match(ev historyEvent) bool {
+	if !f.Since.IsZero() && ev.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && ev.Time.After(f.Until) {
+		return false
+	}
+	if f.Action != "" && ev.Action != f.Action {
+		return false
+	}
+	if f.Pkg != "" {
+		if ok, _ := filepath.Match(f.Pkg, ev.Name); !ok && f.Pkg != ev.Pkgver {
+			return false
+		}
+	}
+	return true
+}
+
+func showHistory(filter historyFilter) {
+	events, err := readHistory()
+	if err != nil {
+		if os.IsPermission(err) && os.Geteuid() != 0 {
+			cmd := exec.Command("sudo", os.Args...)
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+			cmd.Stdin = os.Stdin
+			cmd.Run()
+			return
+		}
+		fmt.Fprintf(os.Stderr, "%s %v\n", red("[!]"), err)
+		return
+	}
+	var selected []historyEvent
+	for _, ev := range events {
+		if filter.match(ev) {
+			selected = append(selected, ev)
+		}
+	}
+	if writeRecords(selected) {
+		return
+	}
+
+	fmt.Printf("\n%s %s\n", cyan("[vinstall]"), white("Histórico:"))
+	width := getTerminalWidth()
+	fmt.Println(white(strings.Repeat("─", width)))
+	for i, ev := range selected {
+		if i == 0 || ev.Transaction != selected[i-1].Transaction {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("%s %s\n", cyan("Transação"), yellow(ev.Transaction))
+		}
+		stamp := ev.Time.Format("2006-01-02 15:04:05")
+		switch ev.Action {
+		case "install":
+			fmt.Printf("  %s %s %s\n", white(stamp), green("instalado "), green(ev.Pkgver))
+		case "update":
+			fmt.Printf("  %s %s %s %s %s\n", white(stamp), yellow("atualizado"), white(ev.OldPkgver), white("->"), yellow(ev.Pkgver))
+		case "remove":
+			fmt.Printf("  %s %s %s\n", white(stamp), red("removido  "), red(ev.Pkgver))
+		}
+	}
+	if len(selected) == 0 {
+		fmt.Printf("%s %s\n", yellow("[!]"), white("Nenhum evento encontrado."))
+	}
+	fmt.Println(white(strings.Repeat("─", width)))
+}
+
+// --- ROLLBACK (--rollback) ---
+
+const xbpsKeysDir = "/var/db/xbps/keys"
+
+// rollbackStep é uma ação do plano: volta o pacote ao estado anterior ao
+// ponto escolhido no histórico.
+type rollbackStep struct {
+	Action    string `json:"action"` // downgrade, reinstall ou remove
+	Name      string `json:"name"`
+	Current   string `json:"current"`
+	Target    string `json:"target"`
+	Archive   string `json:"archive"`
+	Signature string `json:"signature"`
+}
+
+// xbpsKey é um <fingerprint>.plist de /var/db/xbps/keys.
+type xbpsKey struct {
+	PublicKey   []byte `plist:"public-key"`
+	SignatureBy string `plist:"signature-by"`
+}
+
+func loadXbpsKeys() []*rsa.PublicKey {
+	paths, _ := filepath.Glob(filepath.Join(xbpsKeysDir, "*.plist"))
+	var keys []*rsa.PublicKey
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var key xbpsKey
+		if _, err := plist.Unmarshal(data, &key); err != nil {
+			continue
+		}
+		block, _ := pem.Decode(key.PublicKey)
+		if block == nil {
+			continue
+		}
+		if pub, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+			if rsaPub, ok := pub.(*rsa.PublicKey); ok {
+				keys = append(keys, rsaPub)
+			}
+		} else if rsaPub, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+			keys = append(keys, rsaPub)
+		}
+	}
+	return keys
+}
+
+// verifySig2 confere o .sig2 do arquivo: assinatura RSA PKCS#1 v1.5 do
+// SHA256 do pacote, como o xbps-rindex -S gera.
+func verifySig2(archive string, keys []*rsa.PublicKey) error {
+	sig, err := os.ReadFile(archive + ".sig2")
+	if err != nil {
+		return fmt.Errorf("assinatura ausente")
+	}
+	sum, err := fileSha256(archive)
+	if err != nil {
+		return err
+	}
+	digest, _ := hex.DecodeString(sum)
+	for _, key := range keys {
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest, sig) == nil {
+			return nil
+		}
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("nenhuma chave em %s", xbpsKeysDir)
+	}
+	return fmt.Errorf("assinatura inválida")
+}
+
+func cachedArchive(pkgver string) string {
+	for _, arch := range []string{repoArch(), "noarch"} {
+		path := filepath.Join(xbpsCacheDir, pkgver+"."+arch+".xbps")
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// cachedBefore procura no cache a versão mais nova de name baixada antes de
+// since; "" quando não há sinal de que o pacote já estivesse instalado.
+func cachedBefore(name string, since time.Time) string {
+	entries, _ := os.ReadDir(xbpsCacheDir)
+	best := ""
+	for _, e := range entries {
+		pkgver, _, ok := archivePkgver(e.Name())
+		if !ok || patternName(pkgver) != name {
+			continue
+		}
+		if info, err := e.Info(); err != nil || !info.ModTime().Before(since) {
+			continue
+		}
+		if best == "" || cmpVersion(pkgver, best) > 0 {
+			best = pkgver
+		}
+	}
+	return best
+}
+
+// planRollback desfaz, em ordem inversa, os eventos a partir de "since": o
+// estado anterior de cada pacote é dado pelo primeiro evento desfeito dele.
+// Um "Installed" não prova que o pacote era novo: o xbps registra assim
+// também o xbps-install -f. Por isso o estado antes da janela vem do
+// histórico anterior ou, sem ele, de um arquivo mais antigo no cache.
+func planRollback(events []historyEvent, since time.Time, pkgdb map[string]*PkgdbEntry) []rollbackStep {
+	prior := make(map[string]string)  // último pkgver antes de since ("" = removido)
+	before := make(map[string]string) // nome → pkgver anterior ("" = ausente)
+	var order []string
+	for _, ev := range events {
+		if ev.Time.Before(since) {
+			prior[ev.Name] = ev.Pkgver
+			if ev.Action == "remove" {
+				prior[ev.Name] = ""
+			}
+			continue
+		}
+		if _, seen := before[ev.Name]; seen {
+			continue
+		}
+		order = append(order, ev.Name)
+		switch ev.Action {
+		case "install":
+			if pkgver, ok := prior[ev.Name]; ok {
+				before[ev.Name] = pkgver
+			} else {
+				before[ev.Name] = cachedBefore(ev.Name, since)
+			}
+		case "update":
+			before[ev.Name] = ev.OldPkgver
+		case "remove":
+			before[ev.Name] = ev.Pkgver
+		}
+	}
+
+	keys := loadXbpsKeys()
+	var steps []rollbackStep
+	for _, name := range order {
+		step := rollbackStep{Name: name, Target: before[name]}
+		if cur := pkgdb[name]; cur != nil {
+			step.Current = cur.Pkgver
+		}
+		switch {
+		case step.Target == step.Current:
+			continue
+		case step.Target == "":
+			step.Action = "remove"
+		case step.Current == "":
+			step.Action = "reinstall"
+		default:
+			step.Action = "downgrade"
+		}
+		if step.Target != "" {
+			step.Archive = cachedArchive(step.Target)
+			step.Signature = "ok"
+			if step.Archive == "" {
+				step.Signature = "arquivo ausente no cache"
+			} else if err := verifySig2(step.Archive, keys); err != nil {
+				step.Signature = err.Error()
+			}
+		}
+		steps = append(steps, step)
+	}
+	return steps
+}
+
+// rollbackPoint aceita o ID de uma transação (desfaz ela e as seguintes) ou
+// uma data (volta ao estado daquele momento).
+func rollbackPoint(arg string, events []historyEvent) (time.Time, error) {
+	for _, ev := range events {
+		if ev.Transaction == arg {
+			return ev.Time, nil
+		}
+	}
+	t, err := parseHistoryTime(arg, false)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("transação ou data não encontrada: %q", arg)
+	}
+	return t.Add(time.Nanosecond), nil
+}
+
+func rollback(arg string, flags []string) bool {
+	events, err := readHistory()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s %v\n", red("[!]"), err)
+		return false
+	}
+	since, err := rollbackPoint(arg, events)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s %v\n", red("[!]"), err)
+		return false
+	}
+	pkgdb, err := readPkgdb()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s %v\n", red("[!]"), err)
+		return false
+	}
+	steps := planRollback(events, since, pkgdb)
+	if writeRecords(steps) {
+		return true
+	}
+
+	fmt.Printf("%s %s %s\n", cyan("[vinstall]"), white("Rollback para antes de"), yellow(since.Format("2006-01-02 15:04:05")))
+	if len(steps) == 0 {
+		fmt.Printf("%s %s\n", green("[OK]"), white("Nada a desfazer: os pacotes já estão nesse estado."))
+		return true
+	}
+	width := getTerminalWidth()
+	fmt.Println(white(strings.Repeat("─", width)))
+	var installs, removes []string
+	var archives []string
+	blocked := 0
+	for _, st := range steps {
+		switch st.Action {
+		case "remove":
+			fmt.Printf("  %s %s\n", red(fmt.Sprintf("%-10s", st.Action)), white(st.Current))
+			removes = append(removes, st.Name)
+			continue
+		case "downgrade":
+			fmt.Printf("  %s %s %s %s", yellow(fmt.Sprintf("%-10s", st.Action)), white(st.Current), white("->"), yellow(st.Target))
+		default:
+			fmt.Printf("  %s %s", green(fmt.Sprintf("%-10s", st.Action)), green(st.Target))
+		}
+		if st.Signature != "ok" {
+			fmt.Printf("  %s\n", red("["+st.Signature+"]"))
+			blocked++
+			continue
+		}
+		fmt.Println()
+		installs = append(installs, st.Target)
+		archives = append(archives, st.Archive)
+	}
+	fmt.Println(white(strings.Repeat("─", width)))
+	if blocked > 0 {
+		fmt.Printf("%s %s\n", red("[!]"), white(fmt.Sprintf("%d pacote(s) sem arquivo válido em %s; rollback cancelado.", blocked, xbpsCacheDir)))
+		return false
+	}
+
+	fmt.Printf("%s ", white("Aplicar o rollback? [s/N]: "))
+	ans, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if a := strings.ToLower(strings.TrimSpace(ans)); a != "s" && a != "sim" {
+		return false
+	}
+
+	if len(installs) > 0 {
+		// Repositório temporário só com os arquivos verificados, para que o
+		// xbps não resolva as versões pedidas em outro repositório.
+		repo, err := os.MkdirTemp("", "vinstall-rollback-")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s %v\n", red("[!]"), err)
+			return false
+		}
+		defer os.RemoveAll(repo)
+		var copies []string
+		for _, src := range archives {
+			dst := filepath.Join(repo, filepath.Base(src))
+			if err := copyFile(src, dst); err != nil {
+				fmt.Fprintf(os.Stderr, "%s %v\n", red("[!]"), err)
+				return false
+			}
+			copies = append(copies, dst)
+		}
+		cmd := exec.Command("xbps-rindex", append([]string{"-a"}, copies...)...)
+		if targetArch != "" {
+			cmd.Env = append(os.Environ(), "XBPS_ARCH="+targetArch)
+		}
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s xbps-rindex: %v\n", red("[!]"), err)
+			return false
+		}
+		if !runBinary("xbps-install", append([]string{"-R", repo, "-f"}, flags...), installs) {
+			return false
+		}
+	}
+	if len(removes) > 0 && !runBinary("xbps-remove", removeFlags(flags), removes) {
+		return false
+	}
+	fmt.Printf("%s %s\n", yellow("[TIP]"), white("Um -Syu desfaz o rollback; use --hold nos pacotes que devem ficar nessa versão."))
+	return true
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// --- RETENÇÕES (--hold, --unhold, --holds) ---
+
+// pinFile guarda restrições de versão que o xbps não entende, uma por linha
+// no formato de pkgpattern ("linux<6.8", "mesa>=24.0<24.2", "foo-1.[0-9]*").
+// Uma versão nova só passa se casar com o padrão. Linhas com # são
+// comentários.
+const pinFile = "/etc/vinstall/pins"
+
+// holdRecord descreve uma retenção: "hold" é o modo pkg-hold do xbps, o
+// resto é a linha do arquivo de pins.
+type holdRecord struct {
+	Name      string `json:"name"`
+	Rule      string `json:"rule"`
+	Installed string `json:"installed"`
+	Available string `json:"available"`
+	Blocked   bool   `json:"update_blocked"`
+}
+
+func readPins() []string {
+	data, err := os.ReadFile(pinFile)
+	if err != nil {
+		return nil
+	}
+	var pins []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			pins = append(pins, line)
+		}
+	}
+	return pins
+}
+
+func writePins(pins []string) error {
+	content := "# Restrições de versão do vinstall (ex: linux<6.8). Veja vinstall --holds.\n"
+	for _, p := range pins {
+		content += p + "\n"
+	}
+	return writeRootFile(pinFile, content)
+}
+
+// writeRootFile grava arquivos de configuração do sistema. Sem root, grava
+// num temporário e instala com sudo, em vez de reexecutar o vinstall inteiro.
+func writeRootFile(path, content string) error {
+	if os.Geteuid() == 0 {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		return os.WriteFile(path, []byte(content), 0644)
+	}
+	tmp, err := os.CreateTemp("", "vinstall-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if !runBinary("install", []string{"-D", "-m", "0644"}, []string{tmp.Name(), path}) {
+		return fmt.Errorf("falha ao gravar %s", path)
+	}
+	return nil
+}
+
+// holdRecords lista as retenções em vigor; updatesOnly limita às que estão
+// de fato segurando uma versão nova do repositório.
+func holdRecords(index *PackageIndex, pkgdb map[string]*PkgdbEntry, updatesOnly bool) []holdRecord {
+	var records []holdRecord
+	add := func(name, rule string) {
+		r := holdRecord{Name: name, Rule: rule}
+		local := pkgdb[name]
+		if local != nil {
+			r.Installed = local.Pkgver
+		}
+		if pkg := index.Lookup(name); pkg != nil {
+			r.Available = pkg.Pkgver
+			newer := local != nil && cmpVersion(pkg.Pkgver, local.Pkgver) > 0
+			r.Blocked = newer && (rule == "hold" || !pkgpatternMatch(pkg.Pkgver, rule))
+		}
+		if r.Blocked || !updatesOnly {
+			records = append(records, r)
+		}
+	}
+	for _, name := range sortedKeys(pkgdb) {
+		if pkgdb[name].Hold {
+			add(name, "hold")
+		}
+	}
+	for _, pin := range readPins() {
+		if local := pkgdb[patternName(pin)]; local == nil || !local.Hold {
+			add(patternName(pin), pin)
+		}
+	}
+	return records
+}
+
+func showHolds() {
+	records := holdRecords(loadPackageIndex(repoArch()), mustReadPkgdb(), false)
+	if writeRecords(records) {
+		return
+	}
+	if len(records) == 0 {
+		fmt.Printf("%s %s\n", yellow("[!]"), white("Nenhum pacote retido."))
+		return
+	}
+	fmt.Printf("%s %s\n", cyan("[vinstall]"), white("Pacotes retidos:"))
+	for _, r := range records {
+		line := fmt.Sprintf("  %-20s %-20s %s", cyan(r.Name), yellow(r.Rule), white(r.Installed))
+		if r.Blocked {
+			line += " " + red("(retém "+r.Available+")")
+		}
+		fmt.Println(line)
+	}
+}
+
+func mustReadPkgdb() map[string]*PkgdbEntry {
+	pkgdb, err := readPkgdb()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s %v\n", red("[!]"), err)
+		os.Exit(1)
+	}
+	return pkgdb
+}
+
+// setHolds aplica --hold (hold=true) ou --unhold. Nomes simples usam o
+// pkg-hold do xbps; alvos com restrição vão para o arquivo de pins.
+func setHolds(targets []string, hold bool) bool {
+	pins := readPins()
+	newPins := pins[:0:0]
+	drop := make(map[string]bool)
+	for _, t := range targets {
+		drop[patternName(t)] = true
+	}
+	for _, p := range pins {
+		if !drop[patternName(p)] {
+			newPins = append(newPins, p)
+		}
+	}
+	for _, t := range targets {
+		if hold && t != patternName(t) {
+			newPins = append(newPins, t)
+		}
+	}
+	sort.Strings(newPins)
+	changed := strings.Join(newPins, "\n") != strings.Join(pins, "\n")
+
+	pkgdb := mustReadPkgdb()
+	var xbpsNames []string
+	for _, t := range targets {
+		name := patternName(t)
+		switch {
+		case hold && t != name:
+		case pkgdb[name] == nil:
+			if hold {
+				fmt.Printf("%s %s %s\n", yellow("[!]"), white("Pacote não instalado:"), yellow(name))
+			}
+		case pkgdb[name].Hold != hold:
+			xbpsNames = append(xbpsNames, name)
+		}
+	}
+	if changed {
+		if err := writePins(newPins); err != nil {
+			fmt.Fprintf(os.Stderr, "%s %v\n", red("[!]"), err)
+			return false
+		}
+		fmt.Printf("%s %s %s\n", green("[OK]"), white("Pins atualizados em"), yellow(pinFile))
+	}
+	if len(xbpsNames) > 0 {
+		mode := "unhold"
+		if hold {
+			mode = "hold"
+		}
+		return runBinary("xbps-pkgdb", []string{"-m", mode}, xbpsNames)
+	}
+	return true
+}
+
+// tempHolds são pacotes retidos só durante um -Su por causa de um pin. Ficam
+// registrados para que uma interrupção não os deixe presos.
+var tempHolds []string
+
+// withTempHolds põe os pins bloqueantes em pkg-hold enquanto run executa,
+// já que o xbps-install não conhece restrições de versão. Se a retenção
+// falhar, run não é executado e o retorno é false.
+func withTempHolds(names []string, run func()) bool {
+	if len(names) == 0 {
+		run()
+		return true
+	}
+	if !runBinary("xbps-pkgdb", []string{"-m", "hold"}, names) {
+		return false
+	}
+	tempHolds = names
+	defer releaseTempHolds()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	run()
+	return true
+}
+
+func releaseTempHolds() {
+	if len(tempHolds) == 0 {
+		return
+	}
+	names := tempHolds
+	tempHolds = nil
+	runBinary("xbps-pkgdb", []string{"-m", "unhold"}, names)
+}
+
+func reportHeld(held []holdRecord) {
+	if len(held) == 0 || outputFormat != "plain" {
+		return
+	}
+	fmt.Printf("\n%s %s\n", yellow("[!]"), white("Atualizações ignoradas por retenção:"))
+	for _, r := range held {
+		fmt.Printf("  %-20s %s → %s  %s\n", cyan(r.Name), white(r.Installed), yellow(r.Available), magenta("("+r.Rule+")"))
+	}
+}
+
+// --- PACOTES MANUAIS E AUTOMÁTICOS (--explicit, --mark-*, --why) ---
+
+type explicitRecord struct {
+	Name          string `json:"name"`
+	Pkgver        string `json:"pkgver"`
+	InstalledSize int64  `json:"installed_size"`
+	Description   string `json:"description"`
+}
+
+// listExplicit mostra só o que foi pedido explicitamente (sem o
+// automatic-install do pkgdb), ou seja, o que se reinstala numa máquina nova.
+func listExplicit() {
+	pkgdb := mustReadPkgdb()
+	var records []explicitRecord
+	for _, name := range sortedKeys(pkgdb) {
+		if e := pkgdb[name]; !e.AutomaticInstall {
+			records = append(records, explicitRecord{name, e.Pkgver, e.InstalledSize, e.ShortDesc})
+		}
+	}
+	if writeRecords(records) {
+		return
+	}
+	fmt.Printf("%s %s\n", cyan("[vinstall]"), white("Pacotes instalados explicitamente:"))
+	for _, r := range records {
+		fmt.Printf("%-35s %10s  %s\n", white(r.Pkgver), cyan(formatBytes(r.InstalledSize)), r.Description)
+	}
+	fmt.Printf("\n%s %s %s %s %s\n", yellow("[!]"), white("Total:"), cyan(strconv.Itoa(len(records))),
+		white("de"), cyan(strconv.Itoa(len(pkgdb))))
+}
+
+// markPackages aplica --mark-manual (mode "manual") ou --mark-auto ("auto").
+func markPackages(targets []string, mode string) bool {
+	pkgdb := mustReadPkgdb()
+	var names []string
+	for _, t := range targets {
+		name := patternName(t)
+		switch e := pkgdb[name]; {
+		case e == nil:
+			fmt.Printf("%s %s %s\n", yellow("[!]"), white("Pacote não instalado:"), yellow(name))
+		case e.AutomaticInstall == (mode == "auto"):
+			fmt.Printf("%s %s %s %s\n", green("[OK]"), cyan(name), white("já está marcado como"), yellow(mode))
+		default:
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return true
+	}
+	if !runBinary("xbps-pkgdb", []string{"-m", mode}, names) {
+		return false
+	}
+	if mode == "auto" {
+		rdeps := buildDepGraph(nil, pkgdb, true).reversed()
+		for _, name := range names {
+			if len(rdeps.deps[name]) == 0 {
+				fmt.Printf("%s %s %s\n", yellow("[!]"), cyan(name), white("não é dependência de nada e agora é órfão (-Lo, -Scc)"))
+			}
+		}
+	}
+	return true
+}
+
+// installOrigin é a última instalação de um pacote segundo o histórico e os
+// pacotes manuais instalados na mesma transação, normalmente quem o puxou.
+type installOrigin struct {
+	Time        time.Time
+	Transaction string
+	With        []string
+}
+
+func installOrigins(events []historyEvent, pkgdb map[string]*PkgdbEntry) map[string]installOrigin {
+	manualIn := make(map[string][]string)
+	for _, ev := range events {
+		if ev.Action == "install" && pkgdb[ev.Name] != nil && !pkgdb[ev.Name].AutomaticInstall {
+			manualIn[ev.Transaction] = append(manualIn[ev.Transaction], ev.Name)
+		}
+	}
+	origins := make(map[string]installOrigin)
+	for _, ev := range events {
+		if ev.Action != "install" {
+			continue
+		}
+		o := installOrigin{Time: ev.Time, Transaction: ev.Transaction}
+		for _, name := range manualIn[ev.Transaction] {
+			if name != ev.Name {
+				o.With = append(o.With, name)
+			}
+		}
+		origins[ev.Name] = o
+	}
+	return origins
+}
+
+type whyRecord struct {
+	Package     string   `json:"package"`
+	Manual      bool     `json:"manual"`
+	RequiredBy  []string `json:"required_by"` // pacotes manuais que dependem dele
+	Chain       string   `json:"chain"`       // caminho mais curto até o primeiro deles
+	InstalledAt string   `json:"installed_at"`
+	PulledInBy  []string `json:"pulled_in_by"` // manuais instalados na mesma transação
+}
+
+// whyInstalled sobe pelas dependências reversas até encontrar pacotes
+// manuais; a busca em largura dá a menor cadeia para cada um.
+func whyInstalled(name string, pkgdb map[string]*PkgdbEntry, rdeps *depGraph, origins map[string]installOrigin) whyRecord {
+	r := whyRecord{Package: name, Manual: !pkgdb[name].AutomaticInstall}
+	if o, ok := origins[name]; ok {
+		r.InstalledAt, r.PulledInBy = o.Transaction, o.With
+	}
+	if r.Manual {
+		return r
+	}
+	parent := map[string]string{name: ""}
+	queue := []string{name}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for _, up := range rdeps.deps[cur] {
+			if _, seen := parent[up]; seen || pkgdb[up] == nil {
+				continue
+			}
+			parent[up] = cur
+			if !pkgdb[up].AutomaticInstall {
+				r.RequiredBy = append(r.RequiredBy, up)
+				continue
+			}
+			queue = append(queue, up)
+		}
+	}
+	if len(r.RequiredBy) > 0 {
+		var chain []string
+		for n := r.RequiredBy[0]; n != ""; n = parent[n] {
+			chain = append(chain, n)
+		}
+		r.Chain = strings.Join(chain, " → ")
+	}
+	return r
+}
+
+func showWhy(targets []string) {
+	pkgdb := mustReadPkgdb()
+	rdeps := buildDepGraph(nil, pkgdb, true).reversed()
+	events, _ := readHistory()
+	origins := installOrigins(events, pkgdb)
+
+	var records []whyRecord
+	for _, t := range targets {
+		name := patternName(t)
+		if pkgdb[name] == nil {
+			fmt.Fprintf(os.Stderr, "%s %s %s\n", yellow("[!]"), white("Pacote não instalado:"), yellow(name))
+			continue
+		}
+		records = append(records, whyInstalled(name, pkgdb, rdeps, origins))
+	}
+	if writeRecords(records) {
+		return
+	}
+	for _, r := range records {
+		fmt.Printf("%s %s\n", cyan("==>"), white(pkgdb[r.Package].Pkgver))
+		switch {
+		case r.Manual:
+			fmt.Printf("    %s\n", green("instalado explicitamente"))
+		case len(r.RequiredBy) == 0:
+			fmt.Printf("    %s\n", yellow("automático e órfão: nenhum pacote manual depende dele"))
+		default:
+			fmt.Printf("    %s %s\n", white("necessário para:"), cyan(strings.Join(r.RequiredBy, ", ")))
+			fmt.Printf("    %s %s\n", white("cadeia:"), r.Chain)
+		}
+		if len(r.PulledInBy) > 0 {
+			fmt.Printf("    %s %s %s\n", white("instalado junto com"), magenta(strings.Join(r.PulledInBy, ", ")), white("(transação "+r.InstalledAt+")"))
+		} else if r.InstalledAt != "" {
+			fmt.Printf("    %s %s\n", white("instalado na transação"), r.InstalledAt)
+		}
+	}
+}
+
+// --- REVISÃO DE ÓRFÃOS (-Scc) ---
+
+// orphanIgnoreFile lista órfãos que o usuário quer manter; o -Scc não os
+// oferece mais.
+const orphanIgnoreFile = "/etc/vinstall/orphan-ignore"
+
+type orphan struct {
+	Name        string
+	Pkgver      string
+	Size        int64
+	InstallDate string
+	PulledInBy  []string
+}
+
+// findOrphans repete o critério do xbps-query -O: automáticos dos quais
+// nenhum pacote instalado depende, exceto outros órfãos.
+func findOrphans(pkgdb map[string]*PkgdbEntry) []string {
+	rdeps := buildDepGraph(nil, pkgdb, true).reversed()
+	candidates := make(map[string]bool)
+	for name, e := range pkgdb {
+		if e.AutomaticInstall {
+			candidates[name] = true
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for name := range candidates {
+			for _, up := range rdeps.deps[name] {
+				if pkgdb[up] != nil && !candidates[up] {
+					delete(candidates, name)
+					changed = true
+					break
+				}
+			}
+		}
+	}
+	return sortedKeys(candidates)
+}
+
+func readOrphanIgnore() map[string]bool {
+	ignore := make(map[string]bool)
+	data, _ := os.ReadFile(orphanIgnoreFile)
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			ignore[line] = true
+		}
+	}
+	return ignore
+}
+
+func writeOrphanIgnore(ignore map[string]bool) error {
+	content := "# Órfãos que o vinstall -Scc não oferece para remoção, um por linha.\n"
+	for _, name := range sortedKeys(ignore) {
+		content += name + "\n"
+	}
+	return writeRootFile(orphanIgnoreFile, content)
+}
+
+// keepDependencies tira da remoção os órfãos de que um pacote mantido ainda
+// depende; senão o xbps-remove recusa a transação inteira.
+func keepDependencies(remove map[string]bool, pkgdb map[string]*PkgdbEntry) []string {
+	rdeps := buildDepGraph(nil, pkgdb, true).reversed()
+	var kept []string
+	for changed := true; changed; {
+		changed = false
+		for _, name := range sortedKeys(remove) {
+			for _, up := range rdeps.deps[name] {
+				if pkgdb[up] != nil && !remove[up] {
+					delete(remove, name)
+					kept = append(kept, name)
+					changed = true
+					break
+				}
+			}
+		}
+	}
+	sort.Strings(kept)
+	return kept
+}
+
+// orphanPullers lista quem veio na mesma transação que o órfão. Diferente do
+// --why, conta também os pacotes já removidos: o caso típico de órfão é o
+// pacote manual que o trouxe ter saído do sistema.
+func orphanPullers(events []historyEvent, pkgdb map[string]*PkgdbEntry, transaction, name string) []string {
+	var names []string
+	for _, ev := range events {
+		if ev.Action != "install" || ev.Transaction != transaction || ev.Name == name {
+			continue
+		}
+		if pkgdb[ev.Name] == nil || !pkgdb[ev.Name].AutomaticInstall {
+			names = append(names, ev.Name)
+		}
+	}
+	return names
+}
+
+// reviewOrphans substitui o "xbps-remove -o" cego: mostra cada órfão com
+// tamanho, data de instalação e quem o trouxe, e deixa desmarcar, marcar como
+// manual ou ignorar para sempre antes de remover.
+func reviewOrphans() {
+	pkgdb := mustReadPkgdb()
+	ignore := readOrphanIgnore()
+	events, _ := readHistory()
+	origins := installOrigins(events, pkgdb)
+
+	var orphans []orphan
+	for _, name := range findOrphans(pkgdb) {
+		if ignore[name] {
+			continue
+		}
+		e := pkgdb[name]
+		o := orphan{Name: name, Pkgver: e.Pkgver, Size: e.InstalledSize, InstallDate: e.InstallDate}
+		if origin, ok := origins[name]; ok {
+			o.PulledInBy = orphanPullers(events, pkgdb, origin.Transaction, name)
+			if o.InstallDate == "" {
+				o.InstallDate = origin.Time.Format("2006-01-02 15:04")
+			}
+		}
+		orphans = append(orphans, o)
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	for len(orphans) > 0 {
+		var total int64
+		fmt.Printf("\n%s %s\n", yellow("[!]"), white("Órfãos encontrados:"))
+		for i, o := range orphans {
+			origin := "-"
+			if len(o.PulledInBy) > 0 {
+				origin = strings.Join(o.PulledInBy, ", ")
+			}
+			fmt.Printf("  %s %-30s %10s  %-16s  %s %s\n", yellow(fmt.Sprintf("%2d)", i+1)), cyan(o.Pkgver),
+				green(formatBytes(o.Size)), white(strings.TrimSuffix(o.InstallDate, " UTC")), white("veio com:"), magenta(origin))
+			total += o.Size
+		}
+		fmt.Printf("  %s %s\n", white("Total:"), green(formatBytes(total)))
+		fmt.Println(white("Remover quais? 'a' todos, 1 3 5-7, ^4 exclui; m N marca como manual; i N ignora sempre; Enter pula"))
+		fmt.Printf("%s ", yellow(">"))
+		input, _ := reader.ReadString('\n')
+		input = strings.TrimSpace(input)
+		if input == "" || input == "q" {
+			return
+		}
+
+		action, rest := "", input
+		if a, r, ok := strings.Cut(input, " "); ok && (a == "m" || a == "i") {
+			action, rest = a, r
+		}
+		choices, err := parseSelection(rest, len(orphans))
+		if err != nil {
+			fmt.Printf("%s %v\n", red("[!]"), err)
+			continue
+		}
+		if len(choices) == 0 {
+			continue
+		}
+		var names []string
+		chosen := make(map[string]bool)
+		for _, i := range choices {
+			names = append(names, orphans[i].Name)
+			chosen[orphans[i].Name] = true
+		}
+
+		switch action {
+		case "m":
+			if !runBinary("xbps-pkgdb", []string{"-m", "manual"}, names) {
+				continue
+			}
+		case "i":
+			for _, name := range names {
+				ignore[name] = true
+			}
+			if err := writeOrphanIgnore(ignore); err != nil {
+				fmt.Fprintf(os.Stderr, "%s %v\n", red("[!]"), err)
+				continue
+			}
+			fmt.Printf("%s %s %s\n", green("[OK]"), white("Ignorados em"), yellow(orphanIgnoreFile))
+		default:
+			for _, name := range keepDependencies(chosen, pkgdb) {
+				fmt.Printf("%s %s %s\n", yellow("[!]"), cyan(name), white("mantido: é dependência de um pacote que fica"))
+			}
+			if len(chosen) > 0 {
+				runBinary("xbps-remove", []string{}, sortedKeys(chosen))
+			}
+			return
+		}
+
+		// m e i tiram os escolhidos da lista e voltam ao prompt.
+		remaining := orphans[:0]
+		for _, o := range orphans {
+			if !chosen[o.Name] {
+				remaining = append(remaining, o)
+			}
+		}
+		orphans = remaining
+	}
+}
+
+// --- ATUALIZAÇÕES PENDENTES (--updates) ---
+
+// updateRecord é uma atualização disponível; Change diz qual componente
+// mudou: major, minor, patch (demais posições) ou revision.
+type updateRecord struct {
+	Name         string `json:"name"`
+	Installed    string `json:"installed"`
+	Available    string `json:"available"`
+	Change       string `json:"change"`
+	Repo         string `json:"repo"`
+	DownloadSize int64  `json:"download_size"`
+	SizeDelta    int64  `json:"installed_size_delta"`
+	Held         bool   `json:"held"`
+}
+
+// splitPkgver separa "gtk+3-3.24.41_1" em nome, versão e revisão.
+func splitPkgver(pkgver string) (string, string, string) {
+	name := patternName(pkgver)
+	version, revision, _ := strings.Cut(strings.TrimPrefix(pkgver, name+"-"), "_")
+	return name, version, revision
+}
+
+// versionChange devolve o tipo de mudança e a posição, em bytes da versão
+// nova, a partir da qual ela difere da antiga (para destacar).
+func versionChange(oldPkgver, newPkgver string) (string, int) {
+	_, oldVer, oldRev := splitPkgver(oldPkgver)
+	_, newVer, _ := splitPkgver(newPkgver)
+	if oldVer == newVer {
+		if oldRev == "" {
+			return "revision", len(newVer)
+		}
+		return "revision", len(newVer) + 1
+	}
+	oldParts := strings.Split(oldVer, ".")
+	newParts := strings.Split(newVer, ".")
+	offset := 0
+	for i, part := range newParts {
+		if i >= len(oldParts) || oldParts[i] != part {
+			switch i {
+			case 0:
+				return "major", offset
+			case 1:
+				return "minor", offset
+			}
+			return "patch", offset
+		}
+		offset += len(part) + 1
+	}
+	return "patch", len(newVer)
+}
+
+func pendingUpdates(index *PackageIndex, pkgdb map[string]*PkgdbEntry) []updateRecord {
+	held := make(map[string]bool)
+	for _, r := range holdRecords(index, pkgdb, true) {
+		held[r.Name] = true
+	}
+	var updates []updateRecord
+	for _, name := range sortedKeys(pkgdb) {
+		local := pkgdb[name]
+		pkg := index.Lookup(name)
+		if pkg == nil || cmpVersion(pkg.Pkgver, local.Pkgver) <= 0 {
+			continue
+		}
+		change, _ := versionChange(local.Pkgver, pkg.Pkgver)
+		updates = append(updates, updateRecord{
+			Name:         name,
+			Installed:    local.Pkgver,
+			Available:    pkg.Pkgver,
+			Change:       change,
+			Repo:         pkg.Repo,
+			DownloadSize: pkg.FilenameSize,
+			SizeDelta:    pkg.InstalledSize - local.InstalledSize,
+			Held:         held[name],
+		})
+	}
+	return updates
+}
+
+// showUpdates lê só o repodata local e o pkgdb, então dispensa root; o
+// repodata fica tão atual quanto o último -S.
+func showUpdates() {
+	index := loadPackageIndex(repoArch())
+	if len(index.Repos) == 0 {
+		fmt.Fprintf(os.Stderr, "%s %s %s\n", red("[!]"), white("Nenhum repodata encontrado para"), yellow(repoArch()))
+		os.Exit(1)
+	}
+	updates := pendingUpdates(index, mustReadPkgdb())
+	if writeRecords(updates) {
+		return
+	}
+	if len(updates) == 0 {
+		fmt.Printf("%s %s\n", green("[OK]"), white("Sistema atualizado."))
+		return
+	}
+
+	byRepo := make(map[string][]updateRecord)
+	for _, u := range updates {
+		byRepo[u.Repo] = append(byRepo[u.Repo], u)
+	}
+	width := getTerminalWidth()
+	var total, delta int64
+	count := 0
+	for _, repo := range sortedKeys(byRepo) {
+		fmt.Printf("\n%s %s\n", cyan("[repo]"), white(repo))
+		fmt.Println(white(strings.Repeat("─", width)))
+		for _, u := range byRepo[repo] {
+			_, newVer, newRev := splitPkgver(u.Available)
+			full := newVer + "_" + newRev
+			_, at := versionChange(u.Installed, u.Available)
+			highlighted := white(full[:at]) + yellow(full[at:])
+			note := ""
+			if u.Held {
+				note = " " + red("(retido)")
+			} else {
+				total += u.DownloadSize
+				delta += u.SizeDelta
+				count++
+			}
+			fmt.Printf("  %-24s %s → %s  %s  %s%s\n", cyan(u.Name), white(strings.TrimPrefix(u.Installed, u.Name+"-")),
+				highlighted, magenta(fmt.Sprintf("%-8s", u.Change)), yellow(formatBytes(u.DownloadSize)), note)
+		}
+	}
+	fmt.Println(white(strings.Repeat("─", width)))
+	fmt.Printf("%s %s %s   %s %s   %s %s\n", yellow("[!]"), white("Atualizações:"), cyan(strconv.Itoa(count)),
+		white("Download:"), yellow(formatBytes(total)), white("Espaço em disco:"), magenta(formatSignedBytes(delta)))
+}
+
+// --- ÁRVORE DE DEPENDÊNCIAS (--tree) ---
+
+// depGraph é o grafo de run_depends: pacotes instalados vêm do pkgdb (o
+// que está de fato no sistema) e os demais do repodata.
+type depGraph struct {
+	deps      map[string][]string
+	pkgver    map[string]string
+	installed map[string]bool
+}
+
+func buildDepGraph(index *PackageIndex, pkgdb map[string]*PkgdbEntry, installedOnly bool) *depGraph {
+	g := &depGraph{deps: make(map[string][]string), pkgver: make(map[string]string), installed: make(map[string]bool)}
+	raw := make(map[string][]string)
+	providers := make(map[string]string)
+	for name, entry := range pkgdb {
+		g.pkgver[name], g.installed[name], raw[name] = entry.Pkgver, true, entry.RunDepends
+		for _, v := range entry.Provides {
+			providers[patternName(v)] = name
+		}
+	}
+	if !installedOnly {
+		for _, pkg := range index.All() {
+			if _, ok := g.pkgver[pkg.Name]; ok {
+				continue
+			}
+			cand := index.Lookup(pkg.Name)
+			g.pkgver[pkg.Name], raw[pkg.Name] = cand.Pkgver, cand.RunDepends
+			for _, v := range cand.Provides {
+				if _, ok := providers[patternName(v)]; !ok {
+					providers[patternName(v)] = pkg.Name
+				}
+			}
+		}
+	}
+	for name, deps := range raw {
+		for _, dep := range deps {
+			dn := patternName(dep)
+			if _, ok := g.pkgver[dn]; !ok {
+				if p, ok := providers[dn]; ok {
+					dn = p
+				}
+			}
+			g.deps[name] = append(g.deps[name], dn)
+		}
+	}
+	return g
+}
+
+func (g *depGraph) reversed() *depGraph {
+	r := &depGraph{deps: make(map[string][]string), pkgver: g.pkgver, installed: g.installed}
+	for _, name := range sortedKeys(g.deps) {
+		for _, dep := range g.deps[name] {
+			r.deps[dep] = append(r.deps[dep], name)
+		}
+	}
+	return r
+}
+
+type treeNode struct {
+	Name      string      `json:"name"`
+	Pkgver    string      `json:"pkgver"`
+	Installed bool        `json:"installed"`
+	Missing   bool        `json:"missing"`
+	Seen      bool        `json:"seen"`
+	Cycle     bool        `json:"cycle"`
+	Children  []*treeNode `json:"children,omitempty"`
+}
+
+type treeEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// walk expande cada pacote uma única vez: reaparições viram Seen (já
+// mostrado em outro ramo) ou Cycle (ancestral do próprio nó).
+func (g *depGraph) walk(name string, maxDepth int) (*treeNode, []treeEdge) {
+	expanded := make(map[string]bool)
+	path := make(map[string]bool)
+	var edges []treeEdge
+	var visit func(name string, depth int) *treeNode
+	visit = func(name string, depth int) *treeNode {
+		n := &treeNode{Name: name, Pkgver: g.pkgver[name], Installed: g.installed[name]}
+		switch {
+		case n.Pkgver == "":
+			n.Missing = true
+			return n
+		case path[name]:
+			n.Cycle = true
+			return n
+		case expanded[name]:
+			n.Seen = true
+			return n
+		}
+		if maxDepth > 0 && depth >= maxDepth {
+			return n
+		}
+		expanded[name] = true
+		path[name] = true
+		defer delete(path, name)
+		for _, dep := range uniqueStrings(g.deps[name]) {
+			edges = append(edges, treeEdge{From: name, To: dep})
+			n.Children = append(n.Children, visit(dep, depth+1))
+		}
+		return n
+	}
+	return visit(name, 0), edges
+}
+
+func showTree(targets []string, reverse, installedOnly bool, maxDepth int) {
+	pkgdb, _ := readPkgdb()
+	g := buildDepGraph(loadPackageIndex(repoArch()), pkgdb, installedOnly)
+	if reverse {
+		g = g.reversed()
+	}
+	var roots []*treeNode
+	var edges []treeEdge
+	for _, t := range targets {
+		root, e := g.walk(patternName(t), maxDepth)
+		roots = append(roots, root)
+		edges = append(edges, e...)
+	}
+
+	switch outputFormat {
+	case "json":
+		writeRecords(roots)
+		return
+	case "tsv":
+		writeRecords(edges)
+		return
+	case "dot":
+		writeDot(roots, edges, reverse)
+		return
+	}
+	for _, root := range roots {
+		fmt.Println(treeLabel(root))
+		printTree(root.Children, "")
+	}
+}
+
+func treeLabel(n *treeNode) string {
+	label := white(n.Name)
+	if n.Pkgver != "" {
+		label = cyan(n.Pkgver)
+	}
+	switch {
+	case n.Missing:
+		label += " " + red("(não encontrado)")
+	case n.Cycle:
+		label += " " + magenta("(ciclo)")
+	case n.Seen:
+		label += " " + white("(…)")
+	}
+	if n.Installed {
+		label += " " + green("[*]")
+	}
+	return label
+}
+
+func printTree(nodes []*treeNode, prefix string) {
+	for i, n := range nodes {
+		branch, next := "├── ", "│   "
+		if i == len(nodes)-1 {
+			branch, next = "└── ", "    "
+		}
+		fmt.Println(white(prefix+branch) + treeLabel(n))
+		printTree(n.Children, prefix+next)
+	}
+}
+
+// writeDot gera um grafo do Graphviz (dot -Tsvg) com as arestas percorridas.
+func writeDot(roots []*treeNode, edges []treeEdge, reverse bool) {
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	fmt.Fprintln(w, "digraph deps {")
+	fmt.Fprintln(w, "\trankdir=LR;")
+	fmt.Fprintln(w, "\tnode [shape=box, fontname=\"monospace\"];")
+	if reverse {
+		fmt.Fprintln(w, "\tedge [dir=back];")
+	}
+	styled := make(map[string]bool)
+	var style func(n *treeNode)
+	style = func(n *treeNode) {
+		if !styled[n.Name] {
+			styled[n.Name] = true
+			switch {
+			case n.Missing:
+				fmt.Fprintf(w, "\t%q [color=red];\n", n.Name)
+			case n.Installed:
+				fmt.Fprintf(w, "\t%q [style=filled, fillcolor=\"#d8f0d8\"];\n", n.Name)
+			}
+		}
+		for _, c := range n.Children {
+			style(c)
+		}
+	}
+	for _, root := range roots {
+		fmt.Fprintf(w, "\t%q [penwidth=2];\n", root.Name)
+		style(root)
+	}
+	seen := make(map[treeEdge]bool)
+	for _, e := range edges {
+		if !seen[e] {
+			seen[e] = true
+			fmt.Fprintf(w, "\t%q -> %q;\n", e.From, e.To)
+		}
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// --- BIBLIOTECAS COMPARTILHADAS (--check-shlibs) ---
+
+// shlibScanDirs são varridos atrás de ELFs que nenhum pacote instalado
+// declara (compilados à mão, restos de pacotes removidos).
+var shlibScanDirs = []string{"/usr/bin", "/usr/lib"}
+
+// shlibRecord é um soname exigido e não encontrado. Sem File, a exigência
+// vem do shlib-requires do pacote; com File, do DT_NEEDED de um ELF avulso.
+type shlibRecord struct {
+	Package   string   `json:"package"`
+	File      string   `json:"file"`
+	Soname    string   `json:"soname"`
+	Providers []string `json:"providers"`
+}
+
+// libraryDirs junta os diretórios padrão do ld.so e os do ld.so.conf.
+func libraryDirs() []string {
+	dirs := []string{"/usr/lib", "/usr/lib64", "/usr/lib32", "/lib", "/lib64"}
+	var parse func(path string, depth int)
+	parse = func(path string, depth int) {
+		data, err := os.ReadFile(path)
+		if err != nil || depth > 4 {
+			return
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			line, _, _ = strings.Cut(line, "#")
+			line = strings.TrimSpace(line)
+			if rest, ok := strings.CutPrefix(line, "include "); ok {
+				matches, _ := filepath.Glob(strings.TrimSpace(rest))
+				for _, m := range matches {
+					parse(m, depth+1)
+				}
+			} else if strings.HasPrefix(line, "/") {
+				dirs = append(dirs, line)
+			}
+		}
+	}
+	parse("/etc/ld.so.conf", 0)
+	return dirs
+}
+
+// elfNeeded devolve DT_NEEDED e os diretórios de RPATH/RUNPATH (com $ORIGIN
+// resolvido). Arquivos que não são ELF dinâmico devolvem nil.
+func elfNeeded(path string) ([]string, []string) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, nil
+	}
+	defer f.Close()
+	needed, err := f.ImportedLibraries()
+	if err != nil {
+		return nil, nil
+	}
+	var search []string
+	for _, tag := range []elf.DynTag{elf.DT_RPATH, elf.DT_RUNPATH} {
+		values, _ := f.DynString(tag)
+		for _, v := range values {
+			for _, dir := range strings.Split(v, ":") {
+				dir = strings.NewReplacer("$ORIGIN", filepath.Dir(path), "${ORIGIN}", filepath.Dir(path)).Replace(dir)
+				search = append(search, dir)
+			}
+		}
+	}
+	return needed, search
+}
+
+func checkShlibs() bool {
+	pkgdb, err := readPkgdb()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s %v\n", red("[!]"), err)
+		return false
+	}
+	provided := make(map[string]bool)
+	for _, entry := range pkgdb {
+		for _, so := range entry.ShlibProvides {
+			provided[so] = true
+		}
+	}
+	index := loadPackageIndex(repoArch())
+	repoProviders := make(map[string][]string)
+	for _, pkg := range index.All() {
+		for _, so := range pkg.ShlibProvides {
+			repoProviders[so] = append(repoProviders[so], pkg.Pkgver)
+		}
+	}
+	providersOf := func(so string) []string {
+		return uniqueStrings(repoProviders[so])
+	}
+
+	var records []shlibRecord
+	for _, name := range sortedKeys(pkgdb) {
+		for _, so := range pkgdb[name].ShlibRequires {
+			if !provided[so] {
+				records = append(records, shlibRecord{Package: pkgdb[name].Pkgver, Soname: so, Providers: providersOf(so)})
+			}
+		}
+	}
+
+	// ELFs fora do pkgdb: o soname pode vir de qualquer arquivo no caminho
+	// do ld.so, não só de pacotes, então confere no disco também.
+	owners := installedOwners()
+	var files []string
+	for _, dir := range shlibScanDirs {
+		filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if d.Type().IsRegular() && len(owners.owners[path]) == 0 {
+				files = append(files, path)
+			}
+			return nil
+		})
+	}
+	libDirs := libraryDirs()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, runtime.NumCPU())
+	for _, path := range files {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(path string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			needed, search := elfNeeded(path)
+			for _, so := range needed {
+				if provided[so] || libraryExists(so, append(search, libDirs...)) {
+					continue
+				}
+				mu.Lock()
+				records = append(records, shlibRecord{File: path, Soname: so, Providers: providersOf(so)})
+				mu.Unlock()
+			}
+		}(path)
+	}
+	wg.Wait()
+	sort.SliceStable(records, func(i, j int) bool {
+		a, b := records[i], records[j]
+		if (a.File == "") != (b.File == "") {
+			return a.File == ""
+		}
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Package < b.Package
+	})
+
+	if writeRecords(records) {
+		return len(records) == 0
+	}
+	fmt.Printf("%s %s\n", cyan("[vinstall]"), white(fmt.Sprintf("Verificando bibliotecas de %d pacotes e %d arquivos avulsos...", len(pkgdb), len(files))))
+	if len(records) == 0 {
+		fmt.Printf("%s %s\n", green("[OK]"), white("Nenhuma biblioteca compartilhada faltando."))
+		return true
+	}
+	width := getTerminalWidth()
+	fmt.Println(white(strings.Repeat("─", width)))
+	for _, r := range records {
+		who := r.Package
+		if r.File != "" {
+			who = r.File + " (sem pacote)"
+		}
+		fix := red("nenhum pacote no repositório fornece")
+		if len(r.Providers) > 0 {
+			fix = white("fornecido por ") + green(strings.Join(r.Providers, ", "))
+		}
+		fmt.Printf("%s %s %s %s  %s\n", red("[quebrado]"), yellow(who), white("precisa de"), magenta(r.Soname), fix)
+	}
+	fmt.Println(white(strings.Repeat("─", width)))
+	fmt.Printf("%s %s %s\n", red("[!]"), white("Sonames faltando:"), red(strconv.Itoa(len(records))))
+	return false
+}
+
+func libraryExists(soname string, dirs []string) bool {
+	for _, dir := range dirs {
+		if _, err := os.Stat(filepath.Join(dir, soname)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// --- INTEGRIDADE (--verify) ---
+
+// verifyRecord é um problema encontrado num arquivo instalado. Problemas
+// em conf_files são esperados (o usuário edita /etc) e não contam como dano.
+type verifyRecord struct {
+	Package  string `json:"package"`
+	Path     string `json:"path"`
+	Type     string `json:"type"`
+	Problem  string `json:"problem"` // missing, modified, type, link-target, unreadable
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+func (r verifyRecord) damage() bool {
+	return r.Type != "conf" && r.Problem != "unreadable"
+}
+
+type verifyJob struct {
+	pkgver string
+	kind   string
+	file   PkgFile
+}
+
+// verifyFile confere tipo, alvo de link e sha256 de uma entrada do files.plist.
+func verifyFile(job verifyJob) *verifyRecord {
+	rec := &verifyRecord{Package: job.pkgver, Path: job.file.File, Type: job.kind}
+	info, err := os.Lstat(job.file.File)
+	if err != nil {
+		rec.Problem = "missing"
+		if os.IsPermission(err) {
+			rec.Problem = "unreadable"
+		}
+		return rec
+	}
+	switch job.kind {
+	case "dir":
+		if !info.IsDir() {
+			rec.Problem, rec.Expected, rec.Actual = "type", "dir", info.Mode().Type().String()
+			return rec
+		}
+	case "link":
+		if info.Mode()&os.ModeSymlink == 0 {
+			rec.Problem, rec.Expected, rec.Actual = "type", "link", info.Mode().Type().String()
+			return rec
+		}
+		target, _ := os.Readlink(job.file.File)
+		if job.file.Target != "" && !sameLinkTarget(job.file.File, target, job.file.Target) {
+			rec.Problem, rec.Expected, rec.Actual = "link-target", job.file.Target, target
+			return rec
+		}
+	default:
+		if !info.Mode().IsRegular() {
+			rec.Problem, rec.Expected, rec.Actual = "type", "file", info.Mode().Type().String()
+			return rec
+		}
+		if job.file.Sha256 == "" {
+			return nil
+		}
+		sum, err := fileSha256(job.file.File)
+		if err != nil {
+			rec.Problem = "unreadable"
+			return rec
+		}
+		if sum != job.file.Sha256 {
+			rec.Problem, rec.Expected, rec.Actual = "modified", job.file.Sha256, sum
+			return rec
+		}
+	}
+	return nil
+}
+
+// sameLinkTarget segue o xbps_symlink_target: o xbps-create grava alvos
+// relativos como absolutos, então um readlink relativo é resolvido a partir
+// do diretório do link antes de comparar.
+func sameLinkTarget(path, actual, stored string) bool {
+	if filepath.IsAbs(stored) && !filepath.IsAbs(actual) {
+		actual = filepath.Join(filepath.Dir(path), actual)
+		return actual == filepath.Clean(stored)
+	}
+	return actual == stored
+}
+
+func verifyPackages(targets []string, flags []string) bool {
+	pkgdb, err := readPkgdb()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s %v\n", red("[!]"), err)
+		return false
+	}
+	names := sortedKeys(pkgdb)
+	if len(targets) > 0 {
+		names = nil
+		for _, t := range targets {
+			if pkgdb[patternName(t)] == nil {
+				fmt.Fprintf(os.Stderr, "%s %s %s\n", yellow("[!]"), white("Pacote não instalado:"), yellow(t))
+				continue
+			}
+			names = append(names, patternName(t))
+		}
+	}
+
+	jobs := make(chan verifyJob)
+	results := make(chan *verifyRecord)
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				if rec := verifyFile(job); rec != nil {
+					results <- rec
+				}
+			}
+		}()
+	}
+	counts := make(map[string]int)
+	go func() {
+		for _, name := range names {
+			files, err := readPkgFiles(name)
+			if err != nil {
+				continue
+			}
+			pkgver := pkgdb[name].Pkgver
+			groups := []struct {
+				kind  string
+				files []PkgFile
+			}{{"file", files.Files}, {"conf", files.ConfFiles}, {"link", files.Links}, {"dir", files.Dirs}}
+			for _, g := range groups {
+				for _, f := range g.files {
+					counts[pkgver]++
+					jobs <- verifyJob{pkgver: pkgver, kind: g.kind, file: f}
+				}
+			}
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+	var records []verifyRecord
+	for rec := range results {
+		records = append(records, *rec)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Package != records[j].Package {
+			return records[i].Package < records[j].Package
+		}
+		return records[i].Path < records[j].Path
+	})
+
+	var damaged []string
+	byPkg := make(map[string][]verifyRecord)
+	for _, r := range records {
+		byPkg[r.Package] = append(byPkg[r.Package], r)
+		if r.damage() && (len(damaged) == 0 || damaged[len(damaged)-1] != patternName(r.Package)) {
+			damaged = append(damaged, patternName(r.Package))
+		}
+	}
+	if writeRecords(records) {
+		return len(damaged) == 0
+	}
+
+	labels := map[string]string{
+		"missing":     "ausente",
+		"modified":    "modificado",
+		"type":        "tipo alterado",
+		"link-target": "link alterado",
+		"unreadable":  "sem permissão",
+	}
+	unreadable := false
+	for _, name := range names {
+		pkgver := pkgdb[name].Pkgver
+		problems := byPkg[pkgver]
+		if len(problems) == 0 {
+			if len(targets) > 0 {
+				fmt.Printf("%s %s %s\n", green("[OK]"), cyan(pkgver), white(fmt.Sprintf("(%d arquivos)", counts[pkgver])))
+			}
+			continue
+		}
+		tally := make(map[string]int)
+		for _, p := range problems {
+			key := labels[p.Problem]
+			if p.Type == "conf" {
+				key = "conf " + key
+			}
+			tally[key]++
+		}
+		var parts []string
+		for _, k := range sortedKeys(tally) {
+			parts = append(parts, fmt.Sprintf("%d %s", tally[k], k))
+		}
+		fmt.Printf("%s %s %s\n", yellow("[!]"), cyan(pkgver), white(strings.Join(parts, ", ")))
+		for _, p := range problems {
+			line := fmt.Sprintf("      %-14s %s", labels[p.Problem], p.Path)
+			if p.Problem == "link-target" || p.Problem == "type" {
+				line += " (" + p.Expected + " → " + p.Actual + ")"
+			}
+			switch {
+			case p.Problem == "unreadable":
+				unreadable = true
+				fmt.Println(white(line))
+			case p.damage():
+				fmt.Println(red(line))
+			default:
+				fmt.Println(magenta(line + " [conf]"))
+			}
+		}
+	}
+	if unreadable && os.Geteuid() != 0 {
+		fmt.Printf("%s %s\n", yellow("[TIP]"), white("Alguns arquivos só podem ser lidos como root; rode com sudo para verificar tudo."))
+	}
+	if len(damaged) == 0 {
+		fmt.Printf("%s %s\n", green("[OK]"), white(fmt.Sprintf("%d pacote(s) íntegros.", len(names))))
+		return true
+	}
+
+	fmt.Printf("\n%s %s %s\n", red("[!]"), white("Pacotes danificados:"), yellow(strings.Join(damaged, " ")))
+	fmt.Printf("%s ", white(fmt.Sprintf("Reinstalar %d pacote(s) com xbps-install -f? [s/N]: ", len(damaged))))
+	ans, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if a := strings.ToLower(strings.TrimSpace(ans)); a == "s" || a == "sim" {
+		return runBinary("xbps-install", append([]string{"-f"}, flags...), damaged)
+	}
+	return false
+}
+
+// --- MANIFESTO (--apply, --export) ---
+
+// manifestRepoConf recebe os repositórios extras pedidos pelo manifesto.
+const manifestRepoConf = "/etc/xbps.d/50-vinstall-manifest.conf"
+
+// manifest é a lista de pacotes de uma máquina da equipe, por exemplo:
+//
+//	install      = ["firefox", "git", "neovim"]
+//	remove       = ["nano"]
+//	holds        = ["linux6.6", "mesa<24.2"]
+//	services     = ["sshd", "dbus"]
+//	repositories = ["https://repo-fastly.voidlinux.org/current/nonfree"]
+type manifest struct {
+	Install      []string `toml:"install"`
+	Remove       []string `toml:"remove,omitempty"`
+	Holds        []string `toml:"holds,omitempty"`
+	Services     []string `toml:"services,omitempty"`
+	Repositories []string `toml:"repositories,omitempty"`
+}
+
+// manifestStep é uma ação do plano do --apply.
+type manifestStep struct {
+	Action string `json:"action"` // repository, remove, install, hold, service
+	Target string `json:"target"`
+}
+
+func planManifest(m *manifest, pkgdb map[string]*PkgdbEntry) []manifestStep {
+	var steps []manifestStep
+	known := make(map[string]bool)
+	for _, uri := range repodata.Repositories() {
+		known[strings.TrimSuffix(uri, "/")] = true
+	}
+	for _, uri := range m.Repositories {
+		if !known[strings.TrimSuffix(uri, "/")] {
+			steps = append(steps, manifestStep{"repository", uri})
+		}
+	}
+	for _, name := range m.Remove {
+		if pkgdb[patternName(name)] != nil {
+			steps = append(steps, manifestStep{"remove", patternName(name)})
+		}
+	}
+	for _, name := range m.Install {
+		if pkgdb[patternName(name)] == nil {
+			steps = append(steps, manifestStep{"install", name})
+		}
+	}
+	pins := make(map[string]bool)
+	for _, p := range readPins() {
+		pins[p] = true
+	}
+	for _, h := range m.Holds {
+		name := patternName(h)
+		if (h == name && (pkgdb[name] == nil || !pkgdb[name].Hold)) || (h != name && !pins[h]) {
+			steps = append(steps, manifestStep{"hold", h})
+		}
+	}
+	for _, sv := range m.Services {
+		if serviceState(sv) != "enabled" {
+			steps = append(steps, manifestStep{"service", sv})
+		}
+	}
+	return steps
+}
+
+// manifestRepos devolve os repositórios do manifesto que só existem (ou só
+// existiriam) em manifestRepoConf.
+func manifestRepos(m *manifest) []string {
+	elsewhere := make(map[string]bool)
+	for _, kv := range repodata.ReadConf() {
+		if kv.Key == "repository" && kv.File != manifestRepoConf {
+			elsewhere[strings.TrimSuffix(kv.Value, "/")] = true
+		}
+	}
+	var repos []string
+	for _, uri := range m.Repositories {
+		if !elsewhere[strings.TrimSuffix(uri, "/")] {
+			elsewhere[strings.TrimSuffix(uri, "/")] = true
+			repos = append(repos, uri)
+		}
+	}
+	return repos
+}
+
+func applyManifest(path string, flags []string) bool {
+	var m manifest
+	if _, err := toml.DecodeFile(path, &m); err != nil {
+		fmt.Fprintf(os.Stderr, "%s %v\n", red("[!]"), err)
+		return false
+	}
+	steps := planManifest(&m, mustReadPkgdb())
+	if writeRecords(steps) {
+		return true
+	}
+	fmt.Printf("%s %s %s\n", cyan("[vinstall]"), white("Manifesto:"), yellow(path))
+	if len(steps) == 0 {
+		fmt.Printf("%s %s\n", green("[OK]"), white("A máquina já está de acordo com o manifesto."))
+		return true
+	}
+	width := getTerminalWidth()
+	fmt.Println(white(strings.Repeat("─", width)))
+	labels := map[string]string{
+		"repository": cyan("[repositório]"),
+		"remove":     red("[remover]    "),
+		"install":    green("[instalar]   "),
+		"hold":       yellow("[reter]      "),
+		"service":    magenta("[serviço]    "),
+	}
+	byAction := make(map[string][]string)
+	for _, st := range steps {
+		fmt.Printf("%s %s\n", labels[st.Action], white(st.Target))
+		byAction[st.Action] = append(byAction[st.Action], st.Target)
+	}
+	fmt.Println(white(strings.Repeat("─", width)))
+	fmt.Printf("%s ", white("Aplicar o manifesto? [s/N]: "))
+	ans, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if a := strings.ToLower(strings.TrimSpace(ans)); a != "s" && a != "sim" {
+		return false
+	}
+
+	ok := true
+	installFlags := flags
+	if len(byAction["repository"]) > 0 {
+		// O arquivo é reescrito inteiro: leva todos os repositórios do
+		// manifesto que não estão configurados em outro arquivo, não só os
+		// novos, para não perder os de um --apply anterior.
+		content := "# Gerado por vinstall --apply " + path + "\n"
+		for _, uri := range manifestRepos(&m) {
+			content += "repository=" + uri + "\n"
+		}
+		if err := writeRootFile(manifestRepoConf, content); err != nil {
+			fmt.Fprintf(os.Stderr, "%s %v\n", red("[!]"), err)
+			return false
+		}
+		// Repositório novo precisa de índice antes de instalar dele.
+		installFlags = append([]string{"-S"}, flags...)
+	}
+	if names := byAction["remove"]; len(names) > 0 {
+		ok = runBinary("xbps-remove", flags, names) && ok
+	}
+	if names := byAction["install"]; len(names) > 0 {
+		ok = runBinary("xbps-install", installFlags, names) && ok
+	}
+	if holds := byAction["hold"]; len(holds) > 0 {
+		ok = setHolds(holds, true) && ok
+	}
+	for _, sv := range byAction["service"] {
+		switch serviceState(sv) {
+		case "disabled":
+			if err := enableService(sv); err != nil {
+				fmt.Fprintf(os.Stderr, "%s %s %s: %v\n", red("[!]"), white("Falha ao ativar"), yellow(sv), err)
+				ok = false
+			}
+		case "missing":
+			fmt.Fprintf(os.Stderr, "%s %s %s\n", red("[!]"), white("Serviço inexistente em /etc/sv:"), yellow(sv))
+			ok = false
+		}
+	}
+	return ok
+}
+
+// exportManifest descreve a máquina atual: pacotes instalados manualmente,
+// retenções, serviços ativos e repositórios configurados.
+func exportManifest(path string) bool {
+	pkgdb := mustReadPkgdb()
+	m := manifest{Install: []string{}, Repositories: repodata.Repositories()}
+	for _, name := range sortedKeys(pkgdb) {
+		if !pkgdb[name].AutomaticInstall {
+			m.Install = append(m.Install, name)
+		}
+		if pkgdb[name].Hold {
+			m.Holds = append(m.Holds, name)
+		}
+	}
+	m.Holds = append(m.Holds, readPins()...)
+	entries, _ := os.ReadDir("/var/service")
+	for _, e := range entries {
+		m.Services = append(m.Services, e.Name())
+	}
+
+	out := os.Stdout
+	if path != "" {
+		file, err := os.Create(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s %v\n", red("[!]"), err)
+			return false
+		}
+		defer file.Close()
+		out = file
+	}
+	fmt.Fprintf(out, "# Manifesto gerado por vinstall --export em %s\n", time.Now().Format("2006-01-02 15:04"))
+	if err := toml.NewEncoder(out).Encode(m); err != nil {
+		fmt.Fprintf(os.Stderr, "%s %v\n", red("[!]"), err)
+		return false
+	}
+	return true
+}
+
+// --- COMANDO NÃO ENCONTRADO (-F <cmd> --install, --cnf-hook) ---
+
+var commandDirs = []string{"/usr/bin", "/usr/sbin", "/bin", "/sbin"}
+
+// commandProviders encontra os pacotes não instalados que trazem o comando,
+// usando o índice de arquivos se existir e o xbps-query -Ro como reserva.
+func commandProviders(command string) []Package {
+	paths := []string{command}
+	if !strings.Contains(command, "/") {
+		paths = nil
+		for _, dir := range commandDirs {
+			paths = append(paths, dir+"/"+command)
+		}
+	}
+
+	arch := repoArch()
+	pkgdb, _ := readPkgdb()
+	found := make(map[string]string) // pkgver → caminho, só não instalados
+	add := func(matches []fileMatch) {
+		for _, m := range matches {
+			if _, ok := found[m.Pkgver]; !ok && pkgdb[patternName(m.Pkgver)] == nil {
+				found[m.Pkgver] = m.Path
+			}
+		}
+	}
+	if fi, err := loadFileIndex(arch); err == nil {
+		for _, path := range paths {
+			add(fi.find(&fileMatcher{exact: path}))
+		}
+	}
+	// O índice local em geral só cobre o cache; sem candidato nele, o
+	// repodata remoto decide.
+	if len(found) == 0 {
+		results := make([][]fileMatch, len(paths))
+		var wg sync.WaitGroup
+		for i, path := range paths {
+			wg.Add(1)
+			go func(i int, path string) {
+				defer wg.Done()
+				ctx, cancel := context.WithTimeout(context.Background(), execTimeout)
+				defer cancel()
+				output, _ := exec.CommandContext(ctx, "xbps-query", "-Ro", path).Output()
+				results[i] = parseOwnedBy(string(output), "remote")
+			}(i, path)
+		}
+		wg.Wait()
+		for _, matches := range results {
+			add(matches)
+		}
+	}
+
+	index := loadPackageIndex(arch)
+	installed := getInstalledPackages()
+	type candidate struct {
+		pkg   Package
+		score int
+	}
+	var candidates []candidate
+	for pkgver, path := range found {
+		name := patternName(pkgver)
+		pkg := Package{Status: "[-]", FullName: pkgver}
+		if rp := index.Lookup(name); rp != nil {
+			pkg = newSuggestion(rp, installed)
+		}
+		score := 0
+		if name == filepath.Base(command) {
+			score += 100
+		}
+		if strings.HasPrefix(path, "/usr/bin/") {
+			score += 20
+		}
+		for _, suffix := range []string{"-devel", "-doc", "-dbg", "-32bit"} {
+			if strings.HasSuffix(name, suffix) {
+				score -= 30
+			}
+		}
+		candidates = append(candidates, candidate{pkg, score})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.score != b.score {
+			return a.score > b.score
+		}
+		if len(a.pkg.FullName) != len(b.pkg.FullName) {
+			return len(a.pkg.FullName) < len(b.pkg.FullName)
+		}
+		return a.pkg.FullName < b.pkg.FullName
+	})
+	pkgs := make([]Package, len(candidates))
+	for i, c := range candidates {
+		pkgs[i] = c.pkg
+	}
+	return pkgs
+}
+
+// installCommandProvider é o fluxo do -F <cmd> --install: lista quem fornece
+// o comando no menu de sugestões e instala o escolhido.
+func installCommandProvider(command string, flags []string) bool {
+	fmt.Printf("%s %s '%s'...\n", cyan("[vinstall]"), white("Procurando pacote que fornece o comando:"), yellow(command))
+	pkgs := commandProviders(command)
+	if len(pkgs) == 0 {
+		fmt.Printf("%s %s\n", red("[!]"), white("Nenhum pacote disponível fornece '"+command+"'."))
+		return false
+	}
+	return displayMenu(pkgs, flags)
+}
+
+// cnfHooks são os handlers de "command not found" de cada shell. Depois de
+// instalar, o comando original é executado de novo com os mesmos argumentos.
+var cnfHooks = map[string]string{
+	"bash": `# vinstall: adicione ao ~/.bashrc: eval "$(vinstall --cnf-hook bash)"
+command_not_found_handle() {
+	if [ -t 0 ] && [ -t 1 ] && command -v vinstall >/dev/null 2>&1; then
+		printf '%s: comando não encontrado\n' "$1" >&2
+		if command vinstall -F "$1" --install; then
+			"$@"
+			return $?
+		fi
+	else
+		printf '%s: comando não encontrado\n' "$1" >&2
+	fi
+	return 127
+}
+`,
+	"zsh": `# vinstall: adicione ao ~/.zshrc: eval "$(vinstall --cnf-hook zsh)"
+command_not_found_handler() {
+	if [[ -t 0 && -t 1 ]] && (( $+commands[vinstall] )); then
+		print -u2 "$1: comando não encontrado"
+		if command vinstall -F "$1" --install; then
+			"$@"
+			return $?
+		fi
+	else
+		print -u2 "$1: comando não encontrado"
+	fi
+	return 127
+}
+`,
+	"fish": `# vinstall: adicione ao ~/.config/fish/config.fish: vinstall --cnf-hook fish | source
+function fish_command_not_found
+	echo "$argv[1]: comando não encontrado" >&2
+	if isatty stdin; and isatty stdout; and command -q vinstall
+		if command vinstall -F $argv[1] --install
+			$argv
+			return $status
+		end
+	end
+	return 127
+end
+`,
+}
+
+func printCnfHook(shell string) bool {
+	hook, ok := cnfHooks[shell]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "%s %s %s\n", red("[!]"), white("Shell não suportado (bash, zsh ou fish):"), yellow(shell))
+		return false
+	}
+	fmt.Print(hook)
+	return true
 }
 
 func checkXlocateIndex() {
@@ -808,16 +6201,49 @@ func printUsage() {
 	fmt.Printf("  %s %-15s\n", green("vinstall"), white("-Syu"))
 	fmt.Printf("  %s %-15s %s\n", green("vinstall"), white("-X"), white("pacote (Remover)"))
 	fmt.Printf("  %s %-15s %s\n", green("vinstall"), white("-F"), white("ifconfig (Busca local)"))
+	fmt.Printf("  %s %-15s %s\n", green("vinstall"), white("-F"), white("'/usr/bin/ls' | '*.so.1' | 're:^/etc/.*conf$'"))
 	fmt.Printf("  %s %-15s %s\n", green("vinstall"), white("-FR"), white("ifconfig (Busca remota)"))
+	fmt.Printf("  %s %-15s %s\n", green("vinstall"), white("-F"), white("rg --install (Instala quem fornece o comando)"))
+	fmt.Printf("  %s %-15s %s\n", green("vinstall"), white("--cnf-hook"), white("bash|zsh|fish (Handler de 'command not found')"))
+	fmt.Printf("  %s %-15s %s\n", green("vinstall"), white("--update-file-index"), white("[dir de .xbps | dump do xlocate] (Índice offline do -FR)"))
 	fmt.Println("\nAtalhos de Consulta:")
 	fmt.Printf("  %-20s %s\n", green("-Li"), white("Lista todos os pacotes instalados"))
 	fmt.Printf("  %-20s %s\n", green("-Lo"), white("Lista apenas pacotes órfãos"))
 	fmt.Printf("  %-20s %s\n", green("-Ss <query>"), white("Busca termo nos repositórios (Rápido)"))
 	fmt.Printf("  %-20s %s\n", green("-Sss <query>"), white("Busca detalhada nos repositórios (Full Text)"))
+	fmt.Printf("  %-20s %s\n", "", white("ex: -Sss firefox -devel maint:foo; tudo após -Sss é consulta, exceto --output/--arch (use -- antes de termos com --)"))
+	fmt.Printf("  %-20s %s\n", "", white("campos: name: desc: maint: repo: license: dep: installed:yes|no size>50M dsize<1M"))
+	fmt.Printf("  %-20s %s\n", "", white("AND/OR/NOT, -termo, (grupos), \"frases\" e /regex/"))
 	fmt.Printf("  %-20s %s\n", green("-Ssi <query>"), white("Busca termo nos pacotes instalados"))
 	fmt.Printf("  %-20s %s\n", green("-Ssu <query>"), white("Busca termo nos pacotes NÃO instalados"))
+	fmt.Printf("  %-20s %s\n", green("--arch <arch>"), white("Usa o repodata de outra arquitetura (ex: aarch64, x86_64-musl)"))
+	fmt.Printf("  %-20s %s\n", green("-r <rootdir>"), white("Raiz alternativa; obrigatória para instalar com --arch"))
+	fmt.Printf("  %-20s %s\n", green("--tui [filtro]"), white("Navegador interativo de pacotes em tela cheia"))
+	fmt.Printf("  %-20s %s\n", green("--preview <pacote>"), white("Mostra a transação (deps, tamanhos, /etc) sem usar sudo"))
+	fmt.Printf("  %-20s %s\n", green("--check-conflicts"), white("<pacote> Lista arquivos que colidiriam com pacotes instalados"))
+	fmt.Printf("  %-20s %s\n", green("--apply <arq.toml>"), white("Aplica um manifesto (install, remove, holds, services, repositories)"))
+	fmt.Printf("  %-20s %s\n", green("--export [arq.toml]"), white("Gera o manifesto desta máquina"))
+	fmt.Printf("  %-20s %s\n", green("--verify [pkg...]"), white("Confere sha256, links e tipos dos arquivos instalados"))
+	fmt.Printf("  %-20s %s\n", green("--check-shlibs"), white("Procura sonames faltando em pacotes instalados e ELFs sem pacote"))
+	fmt.Printf("  %-20s %s\n", green("--ignore-file-conflicts"), white("Repassado ao xbps-install apenas se informado"))
+	fmt.Println("\nSaída:")
+	fmt.Printf("  %-20s %s\n", green("--output <fmt>"), white("plain (padrão), json ou tsv; cores somem fora de um TTY ou com NO_COLOR"))
 	fmt.Println("\nManutenção:")
-	fmt.Printf("  %-20s %s\n", green("-Scc"), white("Limpa cache e órfãos"))
+	fmt.Printf("  %-20s %s\n", green("--tree <pkg>"), white("Árvore de dependências (--reverse, --depth N, --installed, --output dot|json)"))
+	fmt.Printf("  %-20s %s\n", green("--updates"), white("Lista atualizações pendentes por repositório (sem root)"))
+	fmt.Printf("  %-20s %s\n", green("--hold <pkg>"), white("Retém o pacote no -Syu; 'linux<6.8' grava um pin em "+pinFile))
+	fmt.Printf("  %-20s %s\n", green("--unhold <pkg>"), white("Remove retenção e pins do pacote"))
+	fmt.Printf("  %-20s %s\n", green("--holds"), white("Lista retenções e as atualizações que elas seguram"))
+	fmt.Printf("  %-20s %s\n", green("--explicit"), white("Lista só os pacotes instalados explicitamente"))
+	fmt.Printf("  %-20s %s\n", green("--mark-manual <pkg>"), white("Marca como instalado explicitamente (não vira órfão)"))
+	fmt.Printf("  %-20s %s\n", green("--mark-auto <pkg>"), white("Marca como dependência automática"))
+	fmt.Printf("  %-20s %s\n", green("--why <pkg>"), white("Mostra qual pacote manual mantém o pacote instalado"))
+	fmt.Printf("  %-20s %s\n", green("-Scc"), white("Limpa cache e órfãos (mantém as 3 últimas versões e a instalada)"))
+	fmt.Printf("  %-20s %s\n", "", white("--keep N, --uninstalled-only, --older-than 30d, --max-size 2G, --dry-run"))
+	fmt.Printf("  %-20s %s\n", "", white("--older-than vence --keep; --all apaga tudo (padrão antigo do -Scc)"))
+	fmt.Printf("  %-20s %s\n", "", white("Órfãos são revisados um a um; os mantidos de vez ficam em "+orphanIgnoreFile))
 	fmt.Printf("  %-20s %s\n", green("--history"), white("Mostra histórico de transações"))
+	fmt.Printf("  %-20s %s\n", "", white("--since/--until 2024-07-10|7d, --pkg nome|glob, --action install|update|remove"))
+	fmt.Printf("  %-20s %s\n", green("--rollback <id|data>"), white("Desfaz a transação (e as seguintes) com os pacotes do cache"))
 	fmt.Println()
 }
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/voidlinuxbr/voidbr-vinstall/repodata"
+)
+
+// Casos portados de tests/xbps/libxbps/cmpver e pkgpattern_match do xbps,
+// mais os que o vinstall depende em --updates, pins e -Scc.
+func TestCmpVersion(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		// iguais, com e sem nome
+		{"foo-1.0", "foo-1.0", 0},
+		{"1.0_1", "1.0_1", 0},
+		{"foo-1.0_1", "1.0_1", 0},
+		{"foo-1.0", "foo-1.0.0", 0},
+		{"foo-1.0", "foo-1.00", 0},
+
+		// componentes numéricos
+		{"foo-1.0", "foo-1.1", -1},
+		{"foo-2.18", "foo-2.18.3", -1},
+		{"foo-2.18_1", "foo-2.18.3_1", -1},
+		{"foo-1.10", "foo-1.9", 1},
+		{"foo-10.0", "foo-9.99", 1},
+		{"gtk+3-3.24.41_1", "gtk+3-3.24.40_9", 1},
+		{"python3-3.12.4_1", "python3-3.9.19_1", 1},
+
+		// revisão (_N) só desempata
+		{"foo-1.0", "foo-1.0_1", -1},
+		{"foo-1.0_1", "foo-1.0", 1},
+		{"foo-1.0_1", "foo-1.0_2", -1},
+		{"foo-1.0_10", "foo-1.0_9", 1},
+		{"foo-1.0_9", "foo-1.0.1_1", -1},
+
+		// alpha < beta < pre = rc < final < pl = ponto
+		{"foo-1.0alpha1", "foo-1.0beta1", -1},
+		{"foo-1.0beta2", "foo-1.0rc1", -1},
+		{"foo-1.0rc1", "foo-1.0pre1", 0},
+		{"foo-1.0rc1", "foo-1.0", -1},
+		{"foo-1.0rc2", "foo-1.0rc10", -1},
+		{"foo-1.0alpha", "foo-1.0", -1},
+		{"foo-1.0", "foo-1.0pl1", -1},
+		{"foo-1.0pl1", "foo-1.0.1", 0},
+		{"foo-1.0RC1", "foo-1.0rc1", 0},
+		{"foo-1.0rc1_2", "foo-1.0_1", -1},
+
+		// letra solta vira .N: 1.0a = 1.0.0.1
+		{"foo-1.0a", "foo-1.0", 1},
+		{"foo-1.0a", "foo-1.0b", -1},
+		{"foo-1.0z", "foo-1.0.1", 1},
+		{"openssl-1.1.1w_1", "openssl-1.1.1v_3", 1},
+
+		// prefixo tipo epoch: o primeiro componente domina
+		{"foo-2:1.0_1", "foo-1:9.9_1", 1},
+		{"foo-1:2.0_1", "foo-1:10.0_1", -1},
+		{"foo-1:1.0_1", "foo-1:1.0_1", 0},
+	}
+	for _, tt := range tests {
+		if got := cmpVersion(tt.a, tt.b); got != tt.want {
+			t.Errorf("cmpVersion(%q, %q) = %d, quer %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := cmpVersion(tt.b, tt.a); got != -tt.want {
+			t.Errorf("cmpVersion(%q, %q) = %d, quer %d (simetria)", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestPkgpatternMatch(t *testing.T) {
+	tests := []struct {
+		pkgver, pattern string
+		want            bool
+	}{
+		// igualdade exata
+		{"foo-1.0_1", "foo-1.0_1", true},
+		{"foo-1.0_1", "foo-1.0_2", false},
+
+		// operadores simples
+		{"foo-1.0_1", "foo>=0", true},
+		{"foo-1.0_1", "foo>=1.0_1", true},
+		{"foo-1.0_1", "foo>1.0_1", false},
+		{"foo-1.0_2", "foo>1.0_1", true},
+		{"foo-1.0_1", "foo<1.0_1", false},
+		{"foo-1.0_1", "foo<=1.0_1", true},
+		{"foo-1.0rc1_1", "foo<1.0", true},
+		{"foo-1.0rc1_1", "foo>=1.0", false},
+		{"glibc-2.39_2", "glibc>=2.36_1", true},
+		{"linux6.6-6.6.40_1", "linux6.6<6.8", true},
+
+		// faixas >=...<
+		{"foo-1.0_1", "foo>=1.0<2.0", true},
+		{"foo-1.9.9_1", "foo>=1.0<2.0", true},
+		{"foo-2.0_1", "foo>=1.0<2.0", false},
+		{"foo-0.9_1", "foo>=1.0<2.0", false},
+		{"foo-2.0_1", "foo>=1.0<=2.0_1", true},
+		{"foo-2.0_2", "foo>=1.0<=2.0_1", false},
+		{"foo-1.5_1", "foo>1.0<1.5", false},
+		{"mesa-24.1.5_1", "mesa>=24.0<24.2", true},
+
+		// o nome tem que ser idêntico
+		{"foobar-1.0_1", "foo>=1.0", false},
+		{"foo-1.0_1", "foobar>=1.0", false},
+		{"foo-bar-1.0_1", "foo-bar>=1.0", true},
+
+		// globs
+		{"foo-1.0_1", "foo-[0-9]*", true},
+		{"foo-1.0_1", "foo-1.[0-9]*", true},
+		{"foo-10.0_1", "foo-1.[0-9]*", false},
+		{"foo-1.0_1", "foo-1.0_?", true},
+		{"foobar-1.0_1", "foo-[0-9]*", false},
+		{"foo-1.0_1", "foo-[!2]*", true},
+		{"foo-2.0_1", "foo-[!2]*", false},
+	}
+	for _, tt := range tests {
+		if got := pkgpatternMatch(tt.pkgver, tt.pattern); got != tt.want {
+			t.Errorf("pkgpatternMatch(%q, %q) = %v, quer %v", tt.pkgver, tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestPatternName(t *testing.T) {
+	tests := []struct{ pattern, want string }{
+		{"glibc>=2.36_1", "glibc"},
+		{"foo<2.0", "foo"},
+		{"foo>=1.0<2.0", "foo"},
+		{"foo-1.[0-9]*", "foo"},
+		{"foo-bar-1.0_1", "foo-bar"},
+		{"gtk+3-3.24.41_1", "gtk+3"},
+		{"linux6.6", "linux6.6"},
+		{"xorg-server", "xorg-server"},
+	}
+	for _, tt := range tests {
+		if got := patternName(tt.pattern); got != tt.want {
+			t.Errorf("patternName(%q) = %q, quer %q", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestSplitQueryArgs(t *testing.T) {
+	tests := []struct {
+		args        []string
+		terms, opts []string
+	}{
+		{[]string{"firefox", "-devel"}, []string{"firefox", "-devel"}, nil},
+		{[]string{"firefox", "!esr", "--output", "json"}, []string{"firefox", "!esr"}, []string{"--output", "json"}},
+		{[]string{"--output=tsv", "name:gtk", "-x"}, []string{"name:gtk", "-x"}, []string{"--output=tsv"}},
+		{[]string{"foo", "--", "--bar", "-baz"}, []string{"foo", "--bar", "-baz"}, nil},
+	}
+	for _, tt := range tests {
+		terms, opts := splitQueryArgs(tt.args)
+		if strings.Join(terms, " ") != strings.Join(tt.terms, " ") || strings.Join(opts, " ") != strings.Join(tt.opts, " ") {
+			t.Errorf("splitQueryArgs(%q) = %q, %q; quer %q, %q", tt.args, terms, opts, tt.terms, tt.opts)
+		}
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	pkgs := map[string]*repodata.Package{
+		"firefox":       {Name: "firefox", Pkgver: "firefox-128.0_1", ShortDesc: "Mozilla Firefox web browser", License: "MPL-2.0", InstalledSize: 250 << 20},
+		"firefox-devel": {Name: "firefox-devel", Pkgver: "firefox-devel-128.0_1", ShortDesc: "Mozilla Firefox - development files", License: "MPL-2.0"},
+		"coreutils":     {Name: "coreutils", Pkgver: "coreutils-9.5_1", ShortDesc: "GNU core utilities (/usr/bin/ls)", License: "GPL-3.0-or-later"},
+	}
+	tests := []struct {
+		query string
+		want  string // nomes que casam, em ordem alfabética
+	}{
+		{"firefox", "firefox firefox-devel"},
+		{"firefox -devel", "firefox"},
+		{"firefox !devel", "firefox"},
+		{"firefox NOT name:firefox-devel", "firefox"},
+		{"license:/^mpl/ size>100M", "firefox"},
+		{`desc:"web browser" OR name:coreutils`, "coreutils firefox"},
+		{"/usr/bin/ls", "coreutils"},
+		{"/^core/", "coreutils"},
+		{"(name:firefox OR name:coreutils) -devel", "coreutils firefox"},
+	}
+	for _, tt := range tests {
+		expr, err := parseQuery(tt.query)
+		if err != nil {
+			t.Errorf("parseQuery(%q): %v", tt.query, err)
+			continue
+		}
+		var got []string
+		for _, name := range sortedKeys(pkgs) {
+			if expr.match(pkgs[name], false) {
+				got = append(got, name)
+			}
+		}
+		if strings.Join(got, " ") != tt.want {
+			t.Errorf("parseQuery(%q) casou %q, quer %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestWriteRecordsTSV(t *testing.T) {
+	defer func(f string) { outputFormat = f }(outputFormat)
+	outputFormat = "tsv"
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	// o --preview passa []*txAction
+	writeRecords([]*txAction{
+		{Action: "install", Name: "firefox", Pkgver: "firefox-128.0_1", Repo: "https://repo\tx", ConfFiles: []string{"/etc/firefox.conf"}},
+		nil,
+		{Action: "update", Name: "glibc", Pkgver: "glibc-2.39_2", OldPkgver: "glibc-2.39_1", SizeDelta: -1024},
+	})
+	w.Close()
+	os.Stdout = stdout
+	out, _ := io.ReadAll(r)
+
+	want := "action\tname\tpkgver\told_pkgver\trequired_by\trepo\tdownload_size\tinstalled_size_delta\tconf_files\n" +
+		"install\tfirefox\tfirefox-128.0_1\t\t\thttps://repo x\t0\t0\t[/etc/firefox.conf]\n" +
+		"update\tglibc\tglibc-2.39_2\tglibc-2.39_1\t\t\t0\t-1024\t[]\n"
+	if string(out) != want {
+		t.Errorf("tsv:\n%s\nquer:\n%s", out, want)
+	}
+}
+
+func TestSameLinkTarget(t *testing.T) {
+	tests := []struct {
+		path, actual, stored string
+		want                 bool
+	}{
+		// alvo relativo, gravado absoluto pelo xbps-create
+		{"/usr/lib/libfoo.so", "libfoo.so.1", "/usr/lib/libfoo.so.1", true},
+		{"/usr/bin/vi", "../../bin/busybox", "/bin/busybox", true},
+		{"/usr/lib/libfoo.so", "libfoo.so.2", "/usr/lib/libfoo.so.1", false},
+		// alvo absoluto dos dois lados
+		{"/bin", "/usr/bin", "/usr/bin", true},
+		{"/bin", "/opt/bin", "/usr/bin", false},
+		// alvo relativo gravado como relativo: compara como está
+		{"/usr/lib/libfoo.so", "libfoo.so.1", "libfoo.so.1", true},
+		{"/usr/lib/libfoo.so", "./libfoo.so.1", "libfoo.so.1", false},
+	}
+	for _, tt := range tests {
+		if got := sameLinkTarget(tt.path, tt.actual, tt.stored); got != tt.want {
+			t.Errorf("sameLinkTarget(%q, %q, %q) = %v, quer %v", tt.path, tt.actual, tt.stored, got, tt.want)
+		}
+	}
+}
+
+func TestRemoveFlags(t *testing.T) {
+	tests := []struct{ flags, want []string }{
+		{nil, nil},
+		{[]string{"-S"}, nil},
+		{[]string{"-Syu"}, []string{"-y"}},
+		{[]string{"-f", "-y", "--yes"}, []string{"-y"}},
+		{[]string{"-r", "/mnt/pi", "-Sf"}, []string{"-r", "/mnt/pi"}},
+		{[]string{"-Sy", "-r", "/mnt/pi"}, []string{"-y", "-r", "/mnt/pi"}},
+		{[]string{"-R", "/tmp/repo", "-f"}, nil},
+	}
+	for _, tt := range tests {
+		if got := removeFlags(tt.flags); strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("removeFlags(%q) = %q, quer %q", tt.flags, got, tt.want)
+		}
+	}
+}
+
+func TestRealPath(t *testing.T) {
+	defer func(r string) { rootDir = r }(rootDir)
+	rootDir = t.TempDir()
+	for _, dir := range []string{"usr/bin", "usr/lib", "etc"} {
+		if err := os.MkdirAll(filepath.Join(rootDir, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.Symlink("usr/bin", filepath.Join(rootDir, "bin"))
+	os.Symlink("usr/bin", filepath.Join(rootDir, "sbin"))
+	os.Symlink("usr/lib", filepath.Join(rootDir, "lib"))
+	os.Symlink("/", filepath.Join(rootDir, "fora"))
+
+	tests := []struct{ query, want string }{
+		{"/bin/ls", "/usr/bin/ls"},
+		{"/sbin/../lib//libc.so.6", "/usr/lib/libc.so.6"},
+		{"/lib/firmware", "/usr/lib/firmware"},
+		{"/usr/bin/ls", "/usr/bin/ls"},
+		{"/etc/xbps.d/", "/etc/xbps.d"},
+		{"/bin", "/bin"},
+		// diretório inexistente ou fora do rootdir: fica como veio
+		{"/opt/foo/bar", "/opt/foo/bar"},
+		{"/fora/bin/ls", "/fora/bin/ls"},
+	}
+	for _, tt := range tests {
+		if got := realPath(tt.query); got != tt.want {
+			t.Errorf("realPath(%q) = %q, quer %q", tt.query, got, tt.want)
+		}
+	}
+}
@@ -13,6 +13,8 @@ import (
   "strings"
   "time"
   "strconv"
+
+  "github.com/voidlinuxbr/voidbr-vinstall/repodata"
 )
 
 // Constantes para cores ANSI
@@ -205,7 +207,7 @@ func initCommands() {
     {"info", nil, "<pkg>", "Show information about <package>",
       func(a []string) error {
         if len(a)==0 { return argErr("info <pkg>") }
-        return runQ(append([]string{"-R"}, a...)...)
+        return repoInfo(a)
       }},
 
     {"filelist", []string{"fl"}, "<pkg>", "Show file-list of <package>",
@@ -226,10 +228,21 @@ func initCommands() {
         return runQ(append([]string{"-X"}, a...)...)
       }},
 
+    {"verify", nil, "[pkg...]", "Verify installed files against pkgdb hashes (via vinstall)",
+      func(a []string) error {
+        return runXB("vinstall", append([]string{"--verify"}, a...)...)
+      }},
+
+    {"tree", []string{"t"}, "<pkg> [--reverse] [--depth N] [--output dot|json]", "Show recursive dependency tree (via vinstall)",
+      func(a []string) error {
+        if len(a)==0 { return argErr("tree <pkg> [--reverse] [--depth N] [--output dot|json]") }
+        return runXB("vinstall", append([]string{"--tree"}, a...)...)
+      }},
+
     {"search", []string{"s"}, "<name>", "Search for package by name",
       func(a []string) error {
         if len(a)==0 { return argErr("search <name>") }
-        return runQ(append([]string{"-Rs"}, a...)...)
+        return repoSearch(a)
       }},
 
     {"searchfile", []string{"sf"}, "<file>", "Search for file",
@@ -342,6 +355,100 @@ func runQ(args ...string) error {
   return nil
 }
 
+// openRepos lê o repodata sincronizado; sem nenhum (nunca houve -S), os
+// comandos voltam para o xbps-query.
+func openRepos() []*repodata.Repodata {
+  arch := os.Getenv("XBPS_TARGET_ARCH")
+  if arch == "" { arch = repodata.NativeArch() }
+  repos, err := repodata.OpenAll(arch)
+  if err != nil {
+    fmt.Fprintf(os.Stderr, Yellow+"aviso:"+Reset+" %v\n", err)
+  }
+  return repos
+}
+
+func isInstalled(name string) bool {
+  _, err := os.Stat(filepath.Join("/var/db/xbps", "."+name+"-files.plist"))
+  return err == nil
+}
+
+// repoSearch imita o xbps-query -Rs: todos os termos devem aparecer no nome
+// ou na descrição; vale o primeiro repositório que tiver o pacote.
+func repoSearch(terms []string) error {
+  repos := openRepos()
+  if len(repos) == 0 { return runQ(append([]string{"-Rs"}, terms...)...) }
+
+  seen := map[string]bool{}
+  var found []*repodata.Package
+  for _, repo := range repos {
+    for name, pkg := range repo.Packages {
+      if seen[name] { continue }
+      text := strings.ToLower(pkg.Pkgver+" "+pkg.ShortDesc)
+      match := true
+      for _, t := range terms {
+        if !strings.Contains(text, strings.ToLower(t)) { match = false; break }
+      }
+      if match {
+        seen[name] = true
+        found = append(found, pkg)
+      }
+    }
+  }
+  sort.Slice(found, func(i,j int) bool { return found[i].Name < found[j].Name })
+
+  width := 0
+  for _, p := range found {
+    if len(p.Pkgver) > width { width = len(p.Pkgver) }
+  }
+  for _, p := range found {
+    mark := "[-]"
+    if isInstalled(p.Name) { mark = Green+"[*]"+Reset }
+    fmt.Printf("%s %s%-*s%s %s\n", mark, Bold, width, p.Pkgver, Reset, p.ShortDesc)
+  }
+  if len(found) == 0 {
+    return fmt.Errorf("nenhum pacote encontrado")
+  }
+  return nil
+}
+
+// repoInfo mostra os campos do xbps-query -R direto do repodata.
+func repoInfo(names []string) error {
+  repos := openRepos()
+  if len(repos) == 0 { return runQ(append([]string{"-R"}, names...)...) }
+
+  for _, name := range names {
+    var pkg *repodata.Package
+    for _, repo := range repos {
+      if p, ok := repo.Packages[name]; ok { pkg = p; break }
+    }
+    if pkg == nil {
+      return fmt.Errorf("pacote não encontrado: %s", name)
+    }
+    var buf bytes.Buffer
+    fmt.Fprintf(&buf, "pkgname: %s\n", pkg.Name)
+    fmt.Fprintf(&buf, "pkgver: %s\n", pkg.Pkgver)
+    fmt.Fprintf(&buf, "repository: %s\n", pkg.Repo)
+    fmt.Fprintf(&buf, "short_desc: %s\n", pkg.ShortDesc)
+    fmt.Fprintf(&buf, "architecture: %s\n", pkg.Architecture)
+    fmt.Fprintf(&buf, "maintainer: %s\n", pkg.Maintainer)
+    fmt.Fprintf(&buf, "license: %s\n", pkg.License)
+    fmt.Fprintf(&buf, "homepage: %s\n", pkg.Homepage)
+    fmt.Fprintf(&buf, "build-date: %s\n", pkg.BuildDate)
+    fmt.Fprintf(&buf, "filename-size: %d\n", pkg.FilenameSize)
+    fmt.Fprintf(&buf, "installed_size: %d\n", pkg.InstalledSize)
+    fmt.Fprintf(&buf, "filename-sha256: %s\n", pkg.FilenameSha256)
+    if len(pkg.RunDepends) > 0 {
+      fmt.Fprintf(&buf, "run_depends: %s\n", strings.Join(pkg.RunDepends, " "))
+    }
+    if len(pkg.ShlibRequires) > 0 {
+      fmt.Fprintf(&buf, "shlib-requires: %s\n", strings.Join(pkg.ShlibRequires, " "))
+    }
+    colorizeQuery(os.Stdout, buf.Bytes())
+    fmt.Println()
+  }
+  return nil
+}
+
 func colorizeQuery(w io.Writer, data []byte) {
   for _, line := range strings.Split(string(data), "\n") {
     s := strings.TrimSpace(line)